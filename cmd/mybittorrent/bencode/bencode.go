@@ -0,0 +1,76 @@
+// Package bencode encodes and decodes the BitTorrent serialization format.
+//
+// Values map to Go types much like encoding/json: integers to any int, uint
+// or bool type, strings to string, []byte or [N]byte, lists to slices and
+// arrays, and dictionaries to map[string]T or structs. Struct fields are
+// matched by their `bencode:"key"` tag, with the usual ",omitempty" option
+// and "-" to skip a field. Decoding into interface{} produces int64 (or
+// *big.Int beyond its range), string, []interface{} and map[string]interface{}.
+//
+// RawMessage keeps the exact bytes of a value, which is what info hashes are
+// computed over. Decoding is lenient by default; Decoder.Strict rejects
+// anything that isn't in canonical form (unsorted or duplicate keys, leading
+// zeros, negative zero).
+package bencode
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// RawMessage is a raw encoded bencode value. It decodes to a copy of the
+// value's exact input bytes and encodes as those bytes unchanged.
+type RawMessage []byte
+
+// Marshaler is implemented by types that encode themselves.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types that decode themselves from the raw
+// bytes of a single bencode value, e.g. fields that may be a string or a list.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+const (
+	// DefaultMaxDepth bounds how deeply lists and dictionaries may nest.
+	DefaultMaxDepth = 64
+	// DefaultMaxStringLength bounds a single string, and so the allocation a
+	// hostile length prefix can trigger.
+	DefaultMaxStringLength = 64 << 20
+)
+
+// SyntaxError describes malformed or, in strict mode, non-canonical input.
+type SyntaxError struct {
+	Offset int64
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("bencode: %s at offset %d", e.Msg, e.Offset)
+}
+
+// UnmarshalTypeError is returned in strict mode when a value doesn't fit the
+// Go type it is decoded into. Lenient decoding leaves such fields untouched.
+type UnmarshalTypeError struct {
+	Value string
+	Type  reflect.Type
+	Key   string
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("bencode: cannot decode %s into field %q of type %s", e.Value, e.Key, e.Type)
+	}
+	return fmt.Sprintf("bencode: cannot decode %s into Go value of type %s", e.Value, e.Type)
+}
+
+// MarshalError is returned for Go values that have no bencode form.
+type MarshalError struct {
+	Type reflect.Type
+}
+
+func (e *MarshalError) Error() string {
+	return "bencode: unsupported type " + e.Type.String()
+}
@@ -0,0 +1,252 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type file struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type info struct {
+	Name        string   `bencode:"name"`
+	PieceLength int      `bencode:"piece length"`
+	Pieces      []byte   `bencode:"pieces"`
+	Private     bool     `bencode:"private,omitempty"`
+	Files       []file   `bencode:"files,omitempty"`
+	Hash        [4]byte  `bencode:"hash"`
+	Skipped     string   `bencode:"-"`
+	Comment     *string  `bencode:"comment"`
+	Tags        []string `bencode:"tags,omitempty"`
+}
+
+type metainfo struct {
+	Announce string     `bencode:"announce"`
+	Info     RawMessage `bencode:"info"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	comment := "hi"
+	in := info{
+		Name:        "x",
+		PieceLength: 16384,
+		Pieces:      []byte{0, 1, 2, 'e', ':'},
+		Private:     true,
+		Files:       []file{{Length: 3, Path: []string{"a", "b"}}},
+		Hash:        [4]byte{9, 8, 7, 6},
+		Skipped:     "not encoded",
+		Comment:     &comment,
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "d7:comment2:hi5:filesld6:lengthi3e4:pathl1:a1:beee4:hash4:\x09\x08\x07\x06" +
+		"4:name1:x12:piece lengthi16384e6:pieces5:\x00\x01\x02e:7:privatei1ee"
+	if string(data) != want {
+		t.Fatalf("Marshal = %q, want %q", data, want)
+	}
+	var out info
+	if err := UnmarshalStrict(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Skipped = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip = %+v, want %+v", out, in)
+	}
+}
+
+func TestRawMessagePreservesBytes(t *testing.T) {
+	// the info dictionary is not canonical, and must come back unchanged
+	rawInfo := "d4:name1:x3:agei007ee"
+	data := []byte("d8:announce3:url4:info" + rawInfo + "e")
+	var m metainfo
+	if err := Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Info) != rawInfo {
+		t.Fatalf("Info = %q, want %q", m.Info, rawInfo)
+	}
+	out, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("Marshal = %q, want %q", out, data)
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	var v interface{}
+	if err := Unmarshal([]byte("d1:ai-3e1:bl1:xi99999999999999999999ee1:cdee"), &v); err != nil {
+		t.Fatal(err)
+	}
+	big, _ := new(big.Int).SetString("99999999999999999999", 10)
+	want := map[string]interface{}{
+		"a": int64(-3),
+		"b": []interface{}{"x", big},
+		"c": map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %#v, want %#v", v, want)
+	}
+}
+
+func TestKeysMatchExactly(t *testing.T) {
+	var out struct {
+		Info   string `bencode:"info"`
+		Length int    `bencode:"length"`
+	}
+	if err := Unmarshal([]byte("d4:Info5:upper6:Lengthi7e4:info5:lowere"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Info != "lower" || out.Length != 0 {
+		t.Fatalf("got %+v, want only the lower-case keys bound", out)
+	}
+}
+
+func TestLenientSkipsMismatches(t *testing.T) {
+	var out struct {
+		Name   string `bencode:"name"`
+		Length int    `bencode:"length"`
+	}
+	data := []byte("d6:lengthli1ee4:name1:xe")
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "x" || out.Length != 0 {
+		t.Fatalf("got %+v", out)
+	}
+	var typeErr *UnmarshalTypeError
+	if err := UnmarshalStrict(data, &out); !errors.As(err, &typeErr) || typeErr.Key != "length" {
+		t.Fatalf("UnmarshalStrict error = %v, want a type error for length", err)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		msg   string
+	}{
+		{"d1:bi1e1:ai2ee", "not sorted"},
+		{"d1:ai1e1:ai2ee", "not sorted"},
+		{"i03e", "leading zero"},
+		{"i-0e", "negative zero"},
+		{"02:ab", "leading zero"},
+		{"i1ei2e", "trailing data"},
+	} {
+		var v interface{}
+		if err := Unmarshal([]byte(tt.input), &v); err != nil {
+			t.Errorf("lenient Unmarshal(%q) = %v", tt.input, err)
+		}
+		err := UnmarshalStrict([]byte(tt.input), &v)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("UnmarshalStrict(%q) = %v, want %q", tt.input, err, tt.msg)
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	for _, input := range []string{"", "i12", "5:abc", "l", "di1ei2ee", "x", "i1-2e", "ie", "-1:a"} {
+		var v interface{}
+		if err := Unmarshal([]byte(input), &v); err == nil {
+			t.Errorf("Unmarshal(%q) = %#v, want an error", input, v)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	decode := func(input string, configure func(*Decoder)) error {
+		d := NewDecoder(strings.NewReader(input))
+		configure(d)
+		var v interface{}
+		return d.Decode(&v)
+	}
+	deep := strings.Repeat("l", 5) + strings.Repeat("e", 5)
+	if err := decode(deep, func(d *Decoder) { d.MaxDepth = 5 }); err != nil {
+		t.Errorf("depth 5 with MaxDepth 5: %v", err)
+	}
+	if err := decode(deep, func(d *Decoder) { d.MaxDepth = 4 }); err == nil {
+		t.Error("depth 5 with MaxDepth 4 was accepted")
+	}
+	if err := decode("4:abcd", func(d *Decoder) { d.MaxStringLength = 3 }); err == nil {
+		t.Error("string over MaxStringLength was accepted")
+	}
+	// the length prefix alone must not make the decoder allocate
+	if err := decode("999999999999:", func(d *Decoder) {}); err == nil {
+		t.Error("huge string length was accepted")
+	}
+	if err := decode("l1:a1:b1:ce", func(d *Decoder) { d.MaxSize = 8 }); err == nil {
+		t.Error("value over MaxSize was accepted")
+	}
+	if err := decode("i"+strings.Repeat("1", 40)+"e", func(d *Decoder) {}); err == nil {
+		t.Error("overlong number was accepted")
+	}
+}
+
+func TestDecoderStream(t *testing.T) {
+	input := "d1:ai1eei2e3:xyzrest"
+	d := NewDecoder(strings.NewReader(input))
+	var first map[string]int
+	var second int
+	var third string
+	if err := d.Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if d.InputOffset() != 8 {
+		t.Fatalf("InputOffset = %d, want 8", d.InputOffset())
+	}
+	if err := d.Decode(&second); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Decode(&third); err != nil {
+		t.Fatal(err)
+	}
+	if first["a"] != 1 || second != 2 || third != "xyz" {
+		t.Fatalf("got %v %v %v", first, second, third)
+	}
+	if rest := input[d.InputOffset():]; rest != "rest" {
+		t.Fatalf("rest = %q", rest)
+	}
+}
+
+type either struct {
+	values []string
+}
+
+func (e *either) UnmarshalBencode(data []byte) error {
+	var one string
+	if err := UnmarshalStrict(data, &one); err == nil {
+		e.values = []string{one}
+		return nil
+	}
+	return Unmarshal(data, &e.values)
+}
+
+func TestUnmarshaler(t *testing.T) {
+	var out struct {
+		A either `bencode:"a"`
+		B either `bencode:"b"`
+	}
+	if err := Unmarshal([]byte("d1:a1:x1:bl1:y1:zee"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.A.values, []string{"x"}) || !reflect.DeepEqual(out.B.values, []string{"y", "z"}) {
+		t.Fatalf("got %v %v", out.A.values, out.B.values)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	for _, v := range []interface{}{nil, 1.5, map[int]string{1: "a"}, make(chan int)} {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("Marshal(%#v) succeeded", v)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+// Unmarshal decodes the single bencode value in data into v, which must be a
+// non-nil pointer. It is lenient: non-canonical input is accepted and values
+// of the wrong type are skipped. Use UnmarshalStrict to reject both.
+func Unmarshal(data []byte, v interface{}) error {
+	return unmarshal(data, v, false)
+}
+
+// UnmarshalStrict is like Unmarshal but requires canonical input with no
+// trailing data, and values that match the Go types they are decoded into.
+func UnmarshalStrict(data []byte, v interface{}) error {
+	return unmarshal(data, v, true)
+}
+
+func unmarshal(data []byte, v interface{}, strict bool) error {
+	d := NewDecoder(bytes.NewReader(data))
+	d.Strict = strict
+	raw, err := d.ReadValue()
+	if err != nil {
+		if err == io.EOF {
+			return &SyntaxError{Offset: 0, Msg: "empty input"}
+		}
+		return err
+	}
+	if strict && int(d.InputOffset()) != len(data) {
+		return &SyntaxError{Offset: d.InputOffset(), Msg: "trailing data after value"}
+	}
+	return unmarshalValidated(raw, v, strict)
+}
+
+func unmarshalValidated(raw []byte, v interface{}, strict bool) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("bencode: Unmarshal needs a non-nil pointer")
+	}
+	s := &decodeState{data: raw, strict: strict}
+	return s.value(rv.Elem())
+}
+
+var (
+	rawMessageType  = reflect.TypeOf(RawMessage(nil))
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// decodeState walks input that the scanner has already validated, so it
+// only has to deal with type mismatches.
+type decodeState struct {
+	data   []byte
+	pos    int
+	strict bool
+}
+
+// end returns the offset just past the value starting at pos.
+func (s *decodeState) end(pos int) int {
+	switch c := s.data[pos]; {
+	case c == 'i':
+		return pos + bytes.IndexByte(s.data[pos:], 'e') + 1
+	case c == 'l' || c == 'd':
+		pos++
+		for s.data[pos] != 'e' {
+			pos = s.end(pos)
+		}
+		return pos + 1
+	default:
+		colon := pos + bytes.IndexByte(s.data[pos:], ':')
+		length, _ := strconv.Atoi(string(s.data[pos:colon]))
+		return colon + 1 + length
+	}
+}
+
+func (s *decodeState) skip() {
+	s.pos = s.end(s.pos)
+}
+
+func (s *decodeState) readString() []byte {
+	colon := s.pos + bytes.IndexByte(s.data[s.pos:], ':')
+	length, _ := strconv.Atoi(string(s.data[s.pos:colon]))
+	str := s.data[colon+1 : colon+1+length]
+	s.pos = colon + 1 + length
+	return str
+}
+
+func (s *decodeState) readInt() string {
+	end := s.pos + bytes.IndexByte(s.data[s.pos:], 'e')
+	digits := string(s.data[s.pos+1 : end])
+	s.pos = end + 1
+	return digits
+}
+
+func (s *decodeState) mismatch(what string, v reflect.Value) error {
+	if !s.strict {
+		s.skip()
+		return nil
+	}
+	return &UnmarshalTypeError{Value: what, Type: v.Type()}
+}
+
+func kindName(c byte) string {
+	switch c {
+	case 'i':
+		return "integer"
+	case 'l':
+		return "list"
+	case 'd':
+		return "dictionary"
+	}
+	return "string"
+}
+
+func (s *decodeState) value(v reflect.Value) error {
+	if v.Type() == rawMessageType {
+		end := s.end(s.pos)
+		v.SetBytes(append([]byte(nil), s.data[s.pos:end]...))
+		s.pos = end
+		return nil
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		end := s.end(s.pos)
+		raw := append([]byte(nil), s.data[s.pos:end]...)
+		s.pos = end
+		return v.Addr().Interface().(Unmarshaler).UnmarshalBencode(raw)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return s.value(v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return s.mismatch(kindName(s.data[s.pos]), v)
+		}
+		v.Set(reflect.ValueOf(s.generic()))
+		return nil
+	}
+
+	switch c := s.data[s.pos]; {
+	case c == 'i':
+		return s.integer(v)
+	case c == 'l':
+		return s.list(v)
+	case c == 'd':
+		return s.dict(v)
+	default:
+		return s.str(v)
+	}
+}
+
+func (s *decodeState) integer(v reflect.Value) error {
+	start := s.pos
+	digits := s.readInt()
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(digits, 10, 64)
+		if err == nil && !v.OverflowInt(n) {
+			v.SetInt(n)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(digits, 10, 64)
+		if err == nil && !v.OverflowUint(n) {
+			v.SetUint(n)
+			return nil
+		}
+	case reflect.Bool:
+		if digits == "0" || digits == "1" {
+			v.SetBool(digits == "1")
+			return nil
+		}
+	}
+	s.pos = start
+	return s.mismatch("integer "+digits, v)
+}
+
+func (s *decodeState) str(v reflect.Value) error {
+	start := s.pos
+	str := s.readString()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(string(str))
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte(nil), str...))
+			return nil
+		}
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && v.Len() == len(str) {
+			reflect.Copy(v, reflect.ValueOf(str))
+			return nil
+		}
+	}
+	s.pos = start
+	return s.mismatch("string", v)
+}
+
+func (s *decodeState) list(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		s.pos++
+		slice := reflect.MakeSlice(v.Type(), 0, 0)
+		for s.data[s.pos] != 'e' {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := s.value(elem); err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, elem)
+		}
+		s.pos++
+		v.Set(slice)
+		return nil
+	case reflect.Array:
+		s.pos++
+		i := 0
+		for s.data[s.pos] != 'e' {
+			if i >= v.Len() {
+				if s.strict {
+					return &UnmarshalTypeError{Value: "list longer than array", Type: v.Type()}
+				}
+				s.skip()
+				continue
+			}
+			if err := s.value(v.Index(i)); err != nil {
+				return err
+			}
+			i++
+		}
+		s.pos++
+		return nil
+	}
+	return s.mismatch("list", v)
+}
+
+func (s *decodeState) dict(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return s.mismatch("dictionary", v)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		s.pos++
+		for s.data[s.pos] != 'e' {
+			key := reflect.ValueOf(string(s.readString())).Convert(v.Type().Key())
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := s.value(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+		s.pos++
+		return nil
+	case reflect.Struct:
+		fields := cachedFields(v.Type())
+		s.pos++
+		for s.data[s.pos] != 'e' {
+			key := string(s.readString())
+			f := fields.lookup(key)
+			if f == nil {
+				s.skip()
+				continue
+			}
+			if err := s.value(fieldByIndex(v, f.index)); err != nil {
+				var typeErr *UnmarshalTypeError
+				if errors.As(err, &typeErr) && typeErr.Key == "" {
+					typeErr.Key = key
+				}
+				return err
+			}
+		}
+		s.pos++
+		return nil
+	}
+	return s.mismatch("dictionary", v)
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex that allocates nil embedded pointers.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// generic decodes the value at pos into int64 (*big.Int if it doesn't fit),
+// string, []interface{} or map[string]interface{}.
+func (s *decodeState) generic() interface{} {
+	switch c := s.data[s.pos]; {
+	case c == 'i':
+		digits := s.readInt()
+		n, err := strconv.ParseInt(digits, 10, 64)
+		if err != nil {
+			// out of int64 range; the scanner has checked the digits
+			big, _ := new(big.Int).SetString(digits, 10)
+			return big
+		}
+		return n
+	case c == 'l':
+		s.pos++
+		list := []interface{}{}
+		for s.data[s.pos] != 'e' {
+			list = append(list, s.generic())
+		}
+		s.pos++
+		return list
+	case c == 'd':
+		s.pos++
+		dict := map[string]interface{}{}
+		for s.data[s.pos] != 'e' {
+			key := string(s.readString())
+			dict[key] = s.generic()
+		}
+		s.pos++
+		return dict
+	default:
+		return string(s.readString())
+	}
+}
+
+// lookup finds the field for key. Keys are byte strings, so unlike
+// encoding/json only an exact match counts.
+func (fields structFields) lookup(key string) *field {
+	for i := range fields {
+		if fields[i].name == key {
+			return &fields[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package bencode
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	bigIntType    = reflect.TypeOf((*big.Int)(nil))
+)
+
+// Marshal returns the canonical bencoding of v: dictionary keys are sorted,
+// bools are encoded as i1e/i0e, and nil pointers and interfaces are left out
+// of structs and maps.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeValue(&buf, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encoder writes bencoded values to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (e *Encoder) Encode(v interface{}) error {
+	data, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.WriteString(s)
+}
+
+// isNil reports whether v should be left out of a list, map or struct.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+func encodeValue(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		return &MarshalError{Type: reflect.TypeOf(nil)}
+	}
+	if v.Type() == rawMessageType {
+		if v.Len() == 0 {
+			return &MarshalError{Type: v.Type()}
+		}
+		buf.Write(v.Bytes())
+		return nil
+	}
+	if v.Type() == bigIntType {
+		if v.IsNil() {
+			return &MarshalError{Type: v.Type()}
+		}
+		buf.WriteByte('i')
+		buf.WriteString(v.Interface().(*big.Int).String())
+		buf.WriteByte('e')
+		return nil
+	}
+	if v.Type().Implements(marshalerType) {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return &MarshalError{Type: v.Type()}
+		}
+		data, err := v.Interface().(Marshaler).MarshalBencode()
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return &MarshalError{Type: v.Type()}
+		}
+		return encodeValue(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteString("i1e")
+		} else {
+			buf.WriteString("i0e")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(v.Int(), 10))
+		buf.WriteByte('e')
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatUint(v.Uint(), 10))
+		buf.WriteByte('e')
+	case reflect.String:
+		writeString(buf, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Slice {
+				writeString(buf, string(v.Bytes()))
+			} else {
+				array := make([]byte, v.Len())
+				reflect.Copy(reflect.ValueOf(array), v)
+				writeString(buf, string(array))
+			}
+			return nil
+		}
+		buf.WriteByte('l')
+		for i := 0; i < v.Len(); i++ {
+			if isNil(v.Index(i)) {
+				continue
+			}
+			if err := encodeValue(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return &MarshalError{Type: v.Type()}
+		}
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, key := range keys {
+			elem := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+			if isNil(elem) {
+				continue
+			}
+			writeString(buf, key)
+			if err := encodeValue(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Struct:
+		return encodeStruct(buf, v)
+	default:
+		return &MarshalError{Type: v.Type()}
+	}
+	return nil
+}
+
+func encodeStruct(buf *bytes.Buffer, v reflect.Value) error {
+	buf.WriteByte('d')
+	// fields are kept sorted by key, so they can be written in order
+	for _, f := range cachedFields(v.Type()) {
+		fv, ok := fieldValue(v, f.index)
+		if !ok || isNil(fv) || (f.omitEmpty && isEmpty(fv)) {
+			continue
+		}
+		writeString(buf, f.name)
+		if err := encodeValue(buf, fv); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('e')
+	return nil
+}
+
+// fieldValue follows index through embedded structs, reporting false when
+// it passes through a nil embedded pointer.
+func fieldValue(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields are sorted by name.
+type structFields []field
+
+var fieldCache sync.Map // map[reflect.Type]structFields
+
+func cachedFields(t reflect.Type) structFields {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(structFields)
+	}
+	fields, _ := fieldCache.LoadOrStore(t, typeFields(t, nil))
+	return fields.(structFields)
+}
+
+// typeFields lists the encodable fields of t, flattening untagged embedded
+// structs. Fields of the outer struct win over embedded ones with the same key.
+func typeFields(t reflect.Type, index []int) structFields {
+	var fields structFields
+	seen := make(map[string]bool)
+	var embedded structFields
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, typeFields(ft, fieldIndex)...)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		seen[name] = true
+		fields = append(fields, field{name: name, index: fieldIndex, omitEmpty: opts == "omitempty"})
+	}
+	for _, f := range embedded {
+		if !seen[f.name] {
+			seen[f.name] = true
+			fields = append(fields, f)
+		}
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	return fields
+}
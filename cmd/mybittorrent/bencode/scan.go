@@ -0,0 +1,232 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// maxIntDigits is generous for int64/uint64 while stopping a stream of digits
+// from being buffered forever.
+const maxIntDigits = 32
+
+// Decoder reads and decodes bencode values from an input stream. It buffers
+// its input, so it may read past the end of the value it returns.
+type Decoder struct {
+	r      *bufio.Reader
+	offset int64
+
+	// Strict rejects input that is not in canonical form and values that
+	// don't fit the Go type they are decoded into.
+	Strict bool
+	// MaxDepth limits nesting of lists and dictionaries.
+	MaxDepth int
+	// MaxStringLength limits the length of any single string.
+	MaxStringLength int
+	// MaxSize limits the encoded size of one value; zero means no limit.
+	MaxSize int64
+
+	// buf holds the value currently being scanned
+	buf []byte
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{r: br, MaxDepth: DefaultMaxDepth, MaxStringLength: DefaultMaxStringLength}
+}
+
+// InputOffset is the number of input bytes taken by the values decoded so
+// far. Decoding a message from a byte slice and slicing at InputOffset
+// yields whatever follows it.
+func (d *Decoder) InputOffset() int64 {
+	return d.offset
+}
+
+// Decode reads the next value from the input and stores it in v. It returns
+// io.EOF when the input ends cleanly before a value starts.
+func (d *Decoder) Decode(v interface{}) error {
+	raw, err := d.ReadValue()
+	if err != nil {
+		return err
+	}
+	return unmarshalValidated(raw, v, d.Strict)
+}
+
+// ReadValue reads the next value and returns its raw bytes after checking
+// syntax, limits and, in strict mode, canonical form.
+func (d *Decoder) ReadValue() (RawMessage, error) {
+	d.buf = d.buf[:0]
+	if _, err := d.r.Peek(1); err != nil {
+		return nil, err
+	}
+	if err := d.scanValue(0); err != nil {
+		return nil, err
+	}
+	d.offset += int64(len(d.buf))
+	return append(RawMessage(nil), d.buf...), nil
+}
+
+func (d *Decoder) syntaxError(msg string) error {
+	return &SyntaxError{Offset: d.offset + int64(len(d.buf)), Msg: msg}
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	if d.MaxSize > 0 && int64(len(d.buf)) >= d.MaxSize {
+		return 0, d.syntaxError("value exceeds maximum size")
+	}
+	c, err := d.r.ReadByte()
+	if err == io.EOF {
+		return 0, d.syntaxError("unexpected end of input")
+	}
+	if err != nil {
+		return 0, err
+	}
+	d.buf = append(d.buf, c)
+	return c, nil
+}
+
+func (d *Decoder) peekByte() (byte, error) {
+	c, err := d.r.ReadByte()
+	if err == io.EOF {
+		return 0, d.syntaxError("unexpected end of input")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c, d.r.UnreadByte()
+}
+
+func (d *Decoder) scanValue(depth int) error {
+	c, err := d.peekByte()
+	if err != nil {
+		return err
+	}
+	switch {
+	case c == 'i':
+		d.readByte()
+		_, err := d.scanDigits('e', true)
+		return err
+	case c >= '0' && c <= '9':
+		_, err := d.scanString()
+		return err
+	case c == 'l':
+		if depth >= d.MaxDepth {
+			return d.syntaxError("exceeded maximum nesting depth")
+		}
+		d.readByte()
+		for {
+			c, err := d.peekByte()
+			if err != nil {
+				return err
+			}
+			if c == 'e' {
+				d.readByte()
+				return nil
+			}
+			if err := d.scanValue(depth + 1); err != nil {
+				return err
+			}
+		}
+	case c == 'd':
+		if depth >= d.MaxDepth {
+			return d.syntaxError("exceeded maximum nesting depth")
+		}
+		d.readByte()
+		var previousKey []byte
+		first := true
+		for {
+			c, err := d.peekByte()
+			if err != nil {
+				return err
+			}
+			if c == 'e' {
+				d.readByte()
+				return nil
+			}
+			if c < '0' || c > '9' {
+				return d.syntaxError("dictionary key is not a string")
+			}
+			key, err := d.scanString()
+			if err != nil {
+				return err
+			}
+			if d.Strict && !first && bytes.Compare(previousKey, key) >= 0 {
+				return d.syntaxError("dictionary keys are not sorted and unique")
+			}
+			previousKey, first = append(previousKey[:0], key...), false
+			if err := d.scanValue(depth + 1); err != nil {
+				return err
+			}
+		}
+	}
+	return d.syntaxError("invalid byte " + strconv.QuoteRune(rune(c)))
+}
+
+// scanDigits reads an optionally signed decimal number up to delim and
+// returns it without the delimiter.
+func (d *Decoder) scanDigits(delim byte, signed bool) (string, error) {
+	start := len(d.buf)
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return "", err
+		}
+		if c == delim {
+			break
+		}
+		if len(d.buf)-start > maxIntDigits {
+			return "", d.syntaxError("number too long")
+		}
+		if !(c >= '0' && c <= '9') && !(signed && c == '-' && len(d.buf)-1 == start) {
+			return "", d.syntaxError("invalid byte " + strconv.QuoteRune(rune(c)) + " in number")
+		}
+	}
+	digits := string(d.buf[start : len(d.buf)-1])
+	unsigned := digits
+	if signed && len(digits) > 0 && digits[0] == '-' {
+		unsigned = digits[1:]
+		if d.Strict && unsigned == "0" {
+			return "", d.syntaxError("negative zero")
+		}
+	}
+	if unsigned == "" {
+		return "", d.syntaxError("empty number")
+	}
+	if d.Strict && len(unsigned) > 1 && unsigned[0] == '0' {
+		return "", d.syntaxError("leading zero in number")
+	}
+	return digits, nil
+}
+
+// scanString reads a length-prefixed string and returns its contents.
+func (d *Decoder) scanString() ([]byte, error) {
+	digits, err := d.scanDigits(':', false)
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(digits)
+	if err != nil {
+		return nil, d.syntaxError("invalid string length")
+	}
+	if length > d.MaxStringLength {
+		return nil, d.syntaxError("string exceeds maximum length")
+	}
+	if d.MaxSize > 0 && int64(len(d.buf))+int64(length) > d.MaxSize {
+		return nil, d.syntaxError("value exceeds maximum size")
+	}
+	start := len(d.buf)
+	d.buf = append(d.buf, make([]byte, length)...)
+	if _, err := io.ReadFull(d.r, d.buf[start:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			d.buf = d.buf[:start]
+			return nil, d.syntaxError("unexpected end of input in string")
+		}
+		return nil, err
+	}
+	return d.buf[start:], nil
+}
@@ -1,24 +1,146 @@
 package decode
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"math/big"
+	"os"
 	"strings"
+	"unicode/utf8"
 
-	"github.com/jackpal/bencode-go"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
 )
 
-func decodeBencode(bencodedString string) (interface{}, error) {
-	data, err := bencode.Decode(strings.NewReader(bencodedString))
+func decodeBencode(bencodedData []byte) (interface{}, error) {
+	var data interface{}
+	err := bencode.Unmarshal(bencodedData, &data)
 	return data, err
 }
 
-func DecodeCommand(bencodedValue string) {
-	decoded, err := decodeBencode(bencodedValue)
+// isText reports whether s can be shown as a JSON string as is: valid UTF-8
+// with no control characters besides common whitespace. Anything else, like
+// piece hashes or compact peers, is treated as binary.
+func isText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if (r < 0x20 && r != '\t' && r != '\n' && r != '\r') || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Render converts a decoded value into one that marshals to JSON without
+// mangling binary strings. A binary string becomes {"$hex": "..."} or
+// {"$base64": "..."} depending on binaryEncoding, and a binary dictionary
+// key becomes "$hex:..." or "$base64:...". Keys that start with "$" are
+// encoded the same way even when they are text, so they can't be mistaken
+// for markers.
+func Render(value interface{}, binaryEncoding string) interface{} {
+	switch v := value.(type) {
+	case string:
+		if isText(v) {
+			return v
+		}
+		return map[string]string{"$" + binaryEncoding: encodeBinary(v, binaryEncoding)}
+	case *big.Int:
+		// keep every digit rather than round through float64
+		return json.Number(v.String())
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			list[i] = Render(elem, binaryEncoding)
+		}
+		return list
+	case map[string]interface{}:
+		dict := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			if !isText(key) || strings.HasPrefix(key, "$") {
+				key = "$" + binaryEncoding + ":" + encodeBinary(key, binaryEncoding)
+			}
+			dict[key] = Render(elem, binaryEncoding)
+		}
+		return dict
+	}
+	return value
+}
+
+func encodeBinary(s string, binaryEncoding string) string {
+	if binaryEncoding == "base64" {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	return hex.EncodeToString([]byte(s))
+}
+
+// readInput returns the contents of file, or of stdin when file or the
+// argument is "-", or else the argument itself.
+func readInput(file string, arg string) ([]byte, error) {
+	switch {
+	case file == "-" || (file == "" && arg == "-"):
+		return io.ReadAll(os.Stdin)
+	case file != "":
+		return os.ReadFile(file)
+	}
+	return []byte(arg), nil
+}
+
+func DecodeCommand(args []string) {
+	flags := flag.NewFlagSet("decode", flag.ContinueOnError)
+	file := flags.String("f", "", "read the bencoded value from a file, or stdin if \"-\"")
+	pretty := flags.Bool("pretty", false, "indent the JSON output")
+	path := flags.String("path", "", "print only the value at a path such as info.files[2].path")
+	binaryEncoding := flags.String("binary", "hex", "encoding for binary strings: hex or base64")
+	flags.Usage = func() {
+		fmt.Println("Usage: decode [options] <bencoded value | ->")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+	if *binaryEncoding != "hex" && *binaryEncoding != "base64" {
+		fmt.Println("Unknown binary encoding:", *binaryEncoding)
+		return
+	}
+
+	if *file == "" && flags.NArg() != 1 {
+		flags.Usage()
+		return
+	}
+	input, err := readInput(*file, flags.Arg(0))
+	if err != nil {
+		fmt.Println("Error reading input:", err)
+		return
+	}
+
+	decoded, err := decodeBencode(input)
 	if err != nil {
 		fmt.Println("Error decoding bencoded value:", err)
 		return
 	}
-	jsonOutput, _ := json.Marshal(decoded)
+	if *path != "" {
+		decoded, err = Select(decoded, *path)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	rendered := Render(decoded, *binaryEncoding)
+	var jsonOutput []byte
+	if *pretty {
+		jsonOutput, err = json.MarshalIndent(rendered, "", "  ")
+	} else {
+		jsonOutput, err = json.Marshal(rendered)
+	}
+	if err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		return
+	}
 	fmt.Println(string(jsonOutput))
 }
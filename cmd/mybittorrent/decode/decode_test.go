@@ -0,0 +1,105 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+)
+
+// roundTrip renders input to JSON the way decode does and encodes it back
+// the way encode does.
+func roundTrip(t *testing.T, input string, binaryEncoding string) (string, string) {
+	t.Helper()
+	decoded, err := decodeBencode([]byte(input))
+	if err != nil {
+		t.Fatalf("decoding %q: %v", input, err)
+	}
+	rendered, err := json.Marshal(Render(decoded, binaryEncoding))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(rendered))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+	converted, err := Unrender(value)
+	if err != nil {
+		t.Fatalf("unrendering %s: %v", rendered, err)
+	}
+	encoded, err := bencode.Marshal(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(rendered), string(encoded)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, input := range []string{
+		"i-42e",
+		"i123456789012345678901234567890e",
+		"4:spam",
+		"3:\x00\xff\x01",
+		"le",
+		"de",
+		"d6:pieces4:\x01\x02\x03\x04e",
+		"d4:\xff\xfe\x00\x01i1ee",
+		// text that looks like the markers
+		"d4:$hex2:abe",
+		"d7:$base648:YWJjZA==e",
+		"d7:$hex:615:plaine",
+		"d1:$0:e",
+		"l5:$hex:d4:$hex4:\x00\x01\x02\x03ee",
+		"d4:info" + "d5:filesld6:lengthi1e4:pathl1:aeee4:name1:x12:piece lengthi16384e6:pieces20:" + strings.Repeat("\x9a", 20) + "ee",
+	} {
+		for _, binaryEncoding := range []string{"hex", "base64"} {
+			rendered, encoded := roundTrip(t, input, binaryEncoding)
+			if encoded != input {
+				t.Errorf("%s: %q renders as %s and encodes back as %q", binaryEncoding, input, rendered, encoded)
+			}
+		}
+	}
+}
+
+func TestRenderMarkers(t *testing.T) {
+	rendered, _ := roundTrip(t, "d4:$hex2:ab3:bin2:\x00\x01e", "hex")
+	want := `{"$hex:24686578":"ab","bin":{"$hex":"0001"}}`
+	if rendered != want {
+		t.Errorf("rendered %s, want %s", rendered, want)
+	}
+}
+
+func TestUnrenderDuplicateKeys(t *testing.T) {
+	for _, input := range []string{
+		`{"a": 1, "$hex:61": 2}`,
+		`{"$hex:61": 1, "$base64:YQ==": 2}`,
+	} {
+		decoder := json.NewDecoder(strings.NewReader(input))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Unrender(value); err == nil {
+			t.Errorf("Unrender(%s) accepted keys that collide", input)
+		}
+	}
+}
+
+func TestUnrenderErrors(t *testing.T) {
+	for _, input := range []string{`1.5`, `null`, `{"$hex": "zz"}`, `{"$hex:zz": 1}`, `[1e3]`} {
+		decoder := json.NewDecoder(strings.NewReader(input))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Unrender(value); err == nil {
+			t.Errorf("Unrender(%s) succeeded", input)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+)
+
+// Unrender is the inverse of Render: it turns a JSON value decoded with
+// UseNumber back into one the bencode encoder accepts, expanding the
+// {"$hex": ...} and {"$base64": ...} markers and "$hex:"/"$base64:" keys
+// into raw byte strings. Numbers must be integers, and two keys that expand
+// to the same bytes are an error.
+func Unrender(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, fmt.Errorf("null has no bencode form")
+	case json.Number:
+		return integer(v)
+	case bool, string:
+		return v, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			converted, err := Unrender(elem)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = converted
+		}
+		return list, nil
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for key, elem := range v {
+				if encoded, ok := elem.(string); ok && (key == "$hex" || key == "$base64") {
+					return decodeBinary(key[1:], encoded)
+				}
+			}
+		}
+		dict := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			for _, binaryEncoding := range []string{"hex", "base64"} {
+				if encoded, ok := strings.CutPrefix(key, "$"+binaryEncoding+":"); ok {
+					decoded, err := decodeBinary(binaryEncoding, encoded)
+					if err != nil {
+						return nil, err
+					}
+					key = decoded
+					break
+				}
+			}
+			if _, ok := dict[key]; ok {
+				return nil, fmt.Errorf("duplicate dictionary key %q", key)
+			}
+			converted, err := Unrender(elem)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = converted
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("unsupported JSON value %v", value)
+}
+
+// integer keeps the digits of n as they are, so integers beyond 64 bits
+// survive; -0 is written as the canonical 0.
+func integer(n json.Number) (bencode.RawMessage, error) {
+	digits := strings.TrimPrefix(string(n), "-")
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return nil, fmt.Errorf("number %s is not an integer", n)
+	}
+	if digits == "0" {
+		return bencode.RawMessage("i0e"), nil
+	}
+	return bencode.RawMessage("i" + string(n) + "e"), nil
+}
+
+func decodeBinary(binaryEncoding string, encoded string) (string, error) {
+	var decoded []byte
+	var err error
+	if binaryEncoding == "base64" {
+		decoded, err = base64.StdEncoding.DecodeString(encoded)
+	} else {
+		decoded, err = hex.DecodeString(encoded)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid %s string %q: %v", binaryEncoding, encoded, err)
+	}
+	return string(decoded), nil
+}
+
+func EncodeCommand(args []string) {
+	flags := flag.NewFlagSet("encode", flag.ContinueOnError)
+	file := flags.String("f", "", "read the JSON value from a file, or stdin if \"-\"")
+	output := flags.String("o", "", "write the bencoded value to a file instead of stdout")
+	flags.Usage = func() {
+		fmt.Println("Usage: encode [options] <JSON value | ->")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+
+	if *file == "" && flags.NArg() != 1 {
+		flags.Usage()
+		return
+	}
+	input, err := readInput(*file, flags.Arg(0))
+	if err != nil {
+		fmt.Println("Error reading input:", err)
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(input))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		fmt.Println("Error decoding JSON:", err)
+		return
+	}
+	converted, err := Unrender(value)
+	if err != nil {
+		fmt.Println("Error converting JSON:", err)
+		return
+	}
+	encoded, err := bencode.Marshal(converted)
+	if err != nil {
+		fmt.Println("Error encoding bencoded value:", err)
+		return
+	}
+
+	if *output != "" {
+		if err := os.WriteFile(*output, encoded, 0644); err != nil {
+			fmt.Printf("error writing file %s: %v\n", *output, err)
+		}
+		return
+	}
+	os.Stdout.Write(encoded)
+}
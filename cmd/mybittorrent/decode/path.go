@@ -0,0 +1,93 @@
+package decode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsePath splits a selector such as `info.files[2].path` into dictionary
+// keys (strings) and list indexes (ints). Keys run up to the next '.' or '['
+// and may contain spaces, as in `info.piece length`; a key containing those
+// characters can be quoted: `info["odd.key"]`.
+func parsePath(path string) ([]interface{}, error) {
+	var segments []interface{}
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [ in path %q", path)
+			}
+			inner := path[i+1 : i+end]
+			if strings.HasPrefix(inner, `"`) {
+				key, err := strconv.Unquote(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid quoted key %s in path %q", inner, path)
+				}
+				segments = append(segments, key)
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid list index %q in path %q", inner, path)
+				}
+				segments = append(segments, index)
+			}
+			i += end + 1
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			segments = append(segments, path[i:i+end])
+			i += end
+		}
+	}
+	return segments, nil
+}
+
+// Select returns the part of a decoded value addressed by path.
+func Select(value interface{}, path string) (interface{}, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	walked := ""
+	for _, segment := range segments {
+		switch s := segment.(type) {
+		case string:
+			dict, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not a dictionary", describePath(walked))
+			}
+			value, ok = dict[s]
+			if !ok {
+				return nil, fmt.Errorf("%s has no key %q", describePath(walked), s)
+			}
+			if walked != "" {
+				walked += "."
+			}
+			walked += s
+		case int:
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not a list", describePath(walked))
+			}
+			if s >= len(list) {
+				return nil, fmt.Errorf("index %d out of range, %s has %d entries", s, describePath(walked), len(list))
+			}
+			value = list[s]
+			walked += "[" + strconv.Itoa(s) + "]"
+		}
+	}
+	return value, nil
+}
+
+func describePath(walked string) string {
+	if walked == "" {
+		return "the root value"
+	}
+	return walked
+}
@@ -0,0 +1,688 @@
+// Package dht is a node of the mainline Kademlia DHT (BEP 5), used to find
+// peers for torrents without a tracker.
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
+)
+
+var (
+	// DefaultBootstrapNodes are well-known routers used to join the DHT
+	// when the routing table is empty.
+	DefaultBootstrapNodes = []string{
+		"router.bittorrent.com:6881",
+		"dht.transmissionbt.com:6881",
+		"router.utorrent.com:6881",
+	}
+	// QueryTimeout is how long a query waits for its response.
+	QueryTimeout = 3 * time.Second
+)
+
+const (
+	// alpha is how many queries a lookup has in flight at once.
+	alpha = 3
+	// secretLifetime is how often the token secret rotates; tokens stay
+	// valid for one rotation after that.
+	secretLifetime = 5 * time.Minute
+	// peerLifetime is how long an announced peer is kept.
+	peerLifetime = 30 * time.Minute
+	// maxValues bounds the peers returned for one get_peers.
+	maxValues = 50
+)
+
+type Config struct {
+	// Addr is the UDP address to listen on. Empty tries :6881 and then any
+	// free port.
+	Addr string
+	// BootstrapNodes are host:port addresses asked to join the DHT;
+	// DefaultBootstrapNodes when nil.
+	BootstrapNodes []string
+	// StatePath, if set, keeps the node ID and routing table across runs.
+	StatePath string
+	// PeerPort is the TCP port announced for the torrents looked up. Zero
+	// only looks peers up, for clients that don't accept connections.
+	PeerPort int
+}
+
+type pendingQuery struct {
+	addr string
+	ch   chan *message
+}
+
+// DHT is a running node. It answers queries from other nodes and looks up
+// peers for info hashes on behalf of the client.
+type DHT struct {
+	config Config
+	conn   *net.UDPConn
+	id     [20]byte
+	table  *table
+
+	mu          sync.Mutex
+	pending     map[string]pendingQuery
+	transaction uint16
+	// peers are the announced peers per info hash with when they announced.
+	peers   map[[20]byte]map[string]time.Time
+	secrets [2][]byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Start loads any saved state, listens and starts answering queries. It
+// doesn't contact anyone; call Bootstrap or Discover for that.
+func Start(config Config) (*DHT, error) {
+	if config.BootstrapNodes == nil {
+		config.BootstrapNodes = DefaultBootstrapNodes
+	}
+	d := &DHT{
+		config:  config,
+		pending: make(map[string]pendingQuery),
+		peers:   make(map[[20]byte]map[string]time.Time),
+		done:    make(chan struct{}),
+	}
+	var saved []node
+	if config.StatePath != "" {
+		var err error
+		saved, err = d.load(config.StatePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.id == ([20]byte{}) {
+		rand.Read(d.id[:])
+	}
+	d.table = newTable(d.id)
+	for _, n := range saved {
+		d.table.insert(n)
+	}
+	d.secrets[0] = newSecret()
+	d.secrets[1] = d.secrets[0]
+
+	addrs := []string{config.Addr}
+	if config.Addr == "" {
+		addrs = []string{":6881", ":0"}
+	}
+	var err error
+	for _, addr := range addrs {
+		var udpAddr *net.UDPAddr
+		udpAddr, err = net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving DHT address %s: %v", addr, err)
+		}
+		d.conn, err = net.ListenUDP("udp", udpAddr)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listening for DHT: %v", err)
+	}
+
+	d.wg.Add(2)
+	go d.readLoop()
+	go d.maintain()
+	return d, nil
+}
+
+func (d *DHT) ID() [20]byte {
+	return d.id
+}
+
+func (d *DHT) Addr() *net.UDPAddr {
+	return d.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Close stops the node and saves its state. Only the first call does
+// anything.
+func (d *DHT) Close() error {
+	var err error
+	d.closeOnce.Do(func() {
+		close(d.done)
+		err = d.conn.Close()
+		d.wg.Wait()
+		if d.config.StatePath != "" {
+			if saveErr := d.save(d.config.StatePath); saveErr != nil {
+				err = saveErr
+			}
+		}
+	})
+	return err
+}
+
+func (d *DHT) send(addr *net.UDPAddr, msg *message) error {
+	data, err := bencode.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = d.conn.WriteToUDP(data, addr)
+	return err
+}
+
+func (d *DHT) readLoop() {
+	defer d.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-d.done:
+				return
+			default:
+			}
+			continue
+		}
+		msg := &message{}
+		// anything that isn't a KRPC message is dropped
+		if err := bencode.Unmarshal(buf[:n], msg); err != nil {
+			continue
+		}
+		switch msg.Y {
+		case "q":
+			d.handleQuery(msg, from)
+		case "r", "e":
+			d.mu.Lock()
+			pending, ok := d.pending[msg.T]
+			if ok && pending.addr == from.String() {
+				delete(d.pending, msg.T)
+			}
+			d.mu.Unlock()
+			if ok && pending.addr == from.String() {
+				pending.ch <- msg
+			}
+		}
+	}
+}
+
+// heard records a node that sent a valid message. A full bucket may hand
+// back a questionable node, which is pinged and replaced if it is gone.
+func (d *DHT) heard(id string, addr *net.UDPAddr) {
+	if len(id) != 20 {
+		return
+	}
+	n := node{addr: addr, lastSeen: time.Now()}
+	copy(n.id[:], id)
+	old, ok := d.table.insert(n)
+	if !ok {
+		return
+	}
+	go func() {
+		if _, err := d.Ping(old.addr); err != nil {
+			d.table.replace(old, n)
+		}
+	}()
+}
+
+// query sends a query to addr and waits for its response.
+func (d *DHT) query(addr *net.UDPAddr, method string, args *arguments) (*response, error) {
+	args.ID = string(d.id[:])
+	ch := make(chan *message, 1)
+	d.mu.Lock()
+	d.transaction++
+	t := string([]byte{byte(d.transaction >> 8), byte(d.transaction)})
+	d.pending[t] = pendingQuery{addr: addr.String(), ch: ch}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.pending, t)
+		d.mu.Unlock()
+	}()
+
+	if err := d.send(addr, &message{T: t, Y: "q", Q: method, A: args}); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(QueryTimeout)
+	defer timer.Stop()
+	select {
+	case msg := <-ch:
+		if msg.Y == "e" {
+			return nil, remoteError(msg.E)
+		}
+		if msg.R == nil || len(msg.R.ID) != 20 {
+			return nil, fmt.Errorf("invalid response from %s", addr)
+		}
+		d.heard(msg.R.ID, addr)
+		return msg.R, nil
+	case <-timer.C:
+		d.table.failed(addr)
+		return nil, fmt.Errorf("DHT node %s did not respond", addr)
+	case <-d.done:
+		return nil, fmt.Errorf("DHT node closed")
+	}
+}
+
+// Ping checks that a node is alive and returns its ID.
+func (d *DHT) Ping(addr *net.UDPAddr) ([20]byte, error) {
+	var id [20]byte
+	resp, err := d.query(addr, "ping", &arguments{})
+	if err != nil {
+		return id, err
+	}
+	copy(id[:], resp.ID)
+	return id, nil
+}
+
+func (d *DHT) handleQuery(msg *message, from *net.UDPAddr) {
+	if msg.A == nil || len(msg.A.ID) != 20 {
+		d.send(from, errorMessage(msg.T, errorProtocol, "missing id"))
+		return
+	}
+	d.heard(msg.A.ID, from)
+	resp := &response{ID: string(d.id[:])}
+	switch msg.Q {
+	case "ping":
+	case "find_node":
+		if len(msg.A.Target) != 20 {
+			d.send(from, errorMessage(msg.T, errorProtocol, "invalid target"))
+			return
+		}
+		var target [20]byte
+		copy(target[:], msg.A.Target)
+		resp.Nodes, resp.Nodes6 = encodeNodes(d.table.closest(target, bucketSize))
+	case "get_peers":
+		if len(msg.A.InfoHash) != 20 {
+			d.send(from, errorMessage(msg.T, errorProtocol, "invalid info_hash"))
+			return
+		}
+		var infoHash [20]byte
+		copy(infoHash[:], msg.A.InfoHash)
+		resp.Token = d.token(from.IP, 0)
+		resp.Values = d.storedPeers(infoHash)
+		if len(resp.Values) == 0 {
+			resp.Nodes, resp.Nodes6 = encodeNodes(d.table.closest(infoHash, bucketSize))
+		}
+	case "announce_peer":
+		if len(msg.A.InfoHash) != 20 {
+			d.send(from, errorMessage(msg.T, errorProtocol, "invalid info_hash"))
+			return
+		}
+		if !d.validToken(msg.A.Token, from.IP) {
+			d.send(from, errorMessage(msg.T, errorProtocol, "bad token"))
+			return
+		}
+		port := msg.A.Port
+		if msg.A.ImpliedPort != 0 {
+			port = from.Port
+		}
+		if port <= 0 || port > 65535 {
+			d.send(from, errorMessage(msg.T, errorProtocol, "invalid port"))
+			return
+		}
+		var infoHash [20]byte
+		copy(infoHash[:], msg.A.InfoHash)
+		d.storePeer(infoHash, &net.UDPAddr{IP: from.IP, Port: port})
+	default:
+		d.send(from, errorMessage(msg.T, errorMethod, "method unknown"))
+		return
+	}
+	d.send(from, &message{T: msg.T, Y: "r", R: resp})
+}
+
+func newSecret() []byte {
+	secret := make([]byte, 20)
+	rand.Read(secret)
+	return secret
+}
+
+// token is what a node must present to announce from ip: a hash of ip and
+// the current secret, or the previous one for generation 1.
+func (d *DHT) token(ip net.IP, generation int) string {
+	d.mu.Lock()
+	secret := d.secrets[generation]
+	d.mu.Unlock()
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	sum := sha1.Sum(append(append([]byte(nil), secret...), ip...))
+	return string(sum[:8])
+}
+
+func (d *DHT) validToken(token string, ip net.IP) bool {
+	return token != "" && (token == d.token(ip, 0) || token == d.token(ip, 1))
+}
+
+func (d *DHT) storePeer(infoHash [20]byte, addr *net.UDPAddr) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.peers[infoHash] == nil {
+		d.peers[infoHash] = make(map[string]time.Time)
+	}
+	d.peers[infoHash][string(compactAddr(addr))] = time.Now()
+}
+
+func (d *DHT) storedPeers(infoHash [20]byte) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var values []string
+	for peer := range d.peers[infoHash] {
+		if len(values) == maxValues {
+			break
+		}
+		values = append(values, peer)
+	}
+	return values
+}
+
+// maintain rotates the token secret, expires announced peers and refreshes
+// buckets that have gone quiet.
+func (d *DHT) maintain() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(secretLifetime)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+		d.mu.Lock()
+		d.secrets[1] = d.secrets[0]
+		d.secrets[0] = newSecret()
+		for infoHash, stored := range d.peers {
+			for peer, announced := range stored {
+				if time.Since(announced) > peerLifetime {
+					delete(stored, peer)
+				}
+			}
+			if len(stored) == 0 {
+				delete(d.peers, infoHash)
+			}
+		}
+		d.mu.Unlock()
+		for _, index := range d.table.staleBuckets() {
+			d.lookup(randomIDInBucket(d.id, index), false)
+		}
+	}
+}
+
+// contact is a node met during a lookup, with the token it handed out.
+type contact struct {
+	node
+	queried   bool
+	responded bool
+	token     string
+}
+
+// lookup walks towards target, querying the closest nodes it knows alpha
+// at a time until none of the bucketSize closest is left to ask. With
+// getPeers it sends get_peers and collects peers; either way it returns the
+// closest nodes that responded.
+func (d *DHT) lookup(target [20]byte, getPeers bool) ([]string, []contact) {
+	seen := make(map[string]bool)
+	var contacts []*contact
+	add := func(nodes []node) {
+		for _, n := range nodes {
+			key := n.addr.String()
+			if n.id == d.id || seen[key] {
+				continue
+			}
+			seen[key] = true
+			contacts = append(contacts, &contact{node: n})
+		}
+	}
+	add(d.table.closest(target, bucketSize))
+
+	var mu sync.Mutex
+	foundPeers := make(map[string]bool)
+	var peerList []string
+	for {
+		sortContacts(contacts, target)
+		var batch []*contact
+		for _, c := range contacts[:min(len(contacts), bucketSize)] {
+			if !c.queried && len(batch) < alpha {
+				c.queried = true
+				batch = append(batch, c)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+		var wg sync.WaitGroup
+		for _, c := range batch {
+			wg.Add(1)
+			go func(c *contact) {
+				defer wg.Done()
+				method, args := "find_node", &arguments{Target: string(target[:])}
+				if getPeers {
+					method, args = "get_peers", &arguments{InfoHash: string(target[:])}
+				}
+				resp, err := d.query(c.addr, method, args)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				c.responded = true
+				copy(c.id[:], resp.ID)
+				c.token = resp.Token
+				add(decodeNodes(resp.Nodes, 26))
+				add(decodeNodes(resp.Nodes6, 38))
+				for _, value := range resp.Values {
+					if (len(value) != 6 && len(value) != 18) || foundPeers[value] {
+						continue
+					}
+					foundPeers[value] = true
+					addr := parseCompactAddr([]byte(value))
+					peerList = append(peerList, net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port)))
+				}
+			}(c)
+		}
+		wg.Wait()
+	}
+
+	var closest []contact
+	sortContacts(contacts, target)
+	for _, c := range contacts {
+		if c.responded && len(closest) < bucketSize {
+			closest = append(closest, *c)
+		}
+	}
+	return peerList, closest
+}
+
+func sortContacts(contacts []*contact, target [20]byte) {
+	sort.Slice(contacts, func(i, j int) bool {
+		di, dj := distance(contacts[i].id, target), distance(contacts[j].id, target)
+		return bytes.Compare(di[:], dj[:]) < 0
+	})
+}
+
+// Bootstrap joins the DHT through the saved routing table and the
+// bootstrap nodes, then looks up its own ID to fill the nearby buckets.
+func (d *DHT) Bootstrap() error {
+	var addrs []*net.UDPAddr
+	for _, n := range d.table.nodes() {
+		addrs = append(addrs, n.addr)
+	}
+	for _, hostport := range d.config.BootstrapNodes {
+		addr, err := net.ResolveUDPAddr("udp", hostport)
+		if err != nil {
+			fmt.Println("Error resolving DHT bootstrap node:", err)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reached := 0
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr *net.UDPAddr) {
+			defer wg.Done()
+			if _, err := d.query(addr, "find_node", &arguments{Target: string(d.id[:])}); err == nil {
+				mu.Lock()
+				reached++
+				mu.Unlock()
+			}
+		}(addr)
+	}
+	wg.Wait()
+	if reached == 0 {
+		return errors.New("could not reach any DHT node")
+	}
+	d.lookup(d.id, false)
+	return nil
+}
+
+// errNoResponse means no node in the routing table answered a lookup, e.g.
+// because the saved contacts have all gone.
+var errNoResponse = errors.New("no DHT node responded")
+
+// GetPeers looks up the peers announced for infoHash.
+func (d *DHT) GetPeers(infoHash [20]byte) ([]string, error) {
+	if d.table.len() == 0 {
+		return nil, errors.New("DHT routing table is empty; bootstrap first")
+	}
+	peerList, closest := d.lookup(infoHash, true)
+	if len(closest) == 0 {
+		return nil, errNoResponse
+	}
+	return peerList, nil
+}
+
+// Announce looks up infoHash and announces port to the closest nodes that
+// handed out a token. Port 0 asks them to use the UDP source port.
+func (d *DHT) Announce(infoHash [20]byte, port int) ([]string, error) {
+	if d.table.len() == 0 {
+		return nil, errors.New("DHT routing table is empty; bootstrap first")
+	}
+	peerList, closest := d.lookup(infoHash, true)
+	if len(closest) == 0 {
+		return nil, errNoResponse
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for _, c := range closest {
+		if c.token == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(c contact) {
+			defer wg.Done()
+			args := &arguments{InfoHash: string(infoHash[:]), Port: port, Token: c.token}
+			if port == 0 {
+				args.ImpliedPort = 1
+			}
+			if _, err := d.query(c.addr, "announce_peer", args); err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	if accepted == 0 {
+		return peerList, errors.New("no DHT node accepted the announce")
+	}
+	return peerList, nil
+}
+
+func (d *DHT) Source() peers.Source {
+	return peers.SourceDHT
+}
+
+// Discover joins the DHT if needed, looks the torrent up, announcing it when
+// a PeerPort is configured, and adds the peers found to the pool. Saved
+// contacts that no longer answer are made up for by bootstrapping again.
+func (d *DHT) Discover(infoHash [20]byte, pool *peers.Pool) error {
+	if d.table.len() == 0 {
+		if err := d.Bootstrap(); err != nil {
+			return err
+		}
+	}
+	find := func() ([]string, error) {
+		if d.config.PeerPort == 0 {
+			return d.GetPeers(infoHash)
+		}
+		return d.Announce(infoHash, d.config.PeerPort)
+	}
+	peerList, err := find()
+	if err == errNoResponse {
+		if err := d.Bootstrap(); err != nil {
+			return err
+		}
+		peerList, err = find()
+	}
+	pool.Add(peers.SourceDHT, peerList...)
+	if len(peerList) == 0 {
+		if err != nil {
+			return err
+		}
+		return errors.New("no peers found in the DHT")
+	}
+	return nil
+}
+
+// state is what is saved between runs.
+type state struct {
+	ID     string `bencode:"id"`
+	Nodes  string `bencode:"nodes"`
+	Nodes6 string `bencode:"nodes6,omitempty"`
+}
+
+// DefaultStatePath is where the client keeps its DHT state.
+func DefaultStatePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gotorrent", "dht.dat")
+}
+
+func (d *DHT) save(path string) error {
+	// bad nodes would only slow down the next start
+	var good []node
+	for _, n := range d.table.nodes() {
+		if n.failures < maxFailures {
+			good = append(good, n)
+		}
+	}
+	s := state{ID: string(d.id[:])}
+	s.Nodes, s.Nodes6 = encodeNodes(good)
+	data, err := bencode.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error saving DHT state: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error saving DHT state: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// load reads the saved ID and contacts. A missing file is not an error.
+func (d *DHT) load(path string) ([]node, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading DHT state: %v", err)
+	}
+	s := state{}
+	if err := bencode.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("error parsing DHT state %s: %v", path, err)
+	}
+	if len(s.ID) == 20 {
+		copy(d.id[:], s.ID)
+	}
+	return append(decodeNodes(s.Nodes, 26), decodeNodes(s.Nodes6, 38)...), nil
+}
@@ -0,0 +1,204 @@
+package dht
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
+)
+
+func shortenQueryTimeout(t *testing.T) {
+	old := QueryTimeout
+	QueryTimeout = 300 * time.Millisecond
+	t.Cleanup(func() { QueryTimeout = old })
+}
+
+// startNode runs a node on localhost that joins through bootstrap, which
+// may be empty for the first node of a cluster.
+func startNode(t *testing.T, config Config, bootstrap ...*DHT) *DHT {
+	t.Helper()
+	config.Addr = "127.0.0.1:0"
+	config.BootstrapNodes = []string{}
+	for _, b := range bootstrap {
+		config.BootstrapNodes = append(config.BootstrapNodes, b.Addr().String())
+	}
+	d, err := Start(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if len(bootstrap) > 0 {
+		if err := d.Bootstrap(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+// startCluster starts n nodes, each joining through the first.
+func startCluster(t *testing.T, n int) []*DHT {
+	t.Helper()
+	shortenQueryTimeout(t)
+	nodes := []*DHT{startNode(t, Config{})}
+	for len(nodes) < n {
+		nodes = append(nodes, startNode(t, Config{}, nodes[0]))
+	}
+	return nodes
+}
+
+func TestPing(t *testing.T) {
+	nodes := startCluster(t, 2)
+	id, err := nodes[0].Ping(nodes[1].Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != nodes[1].ID() {
+		t.Fatal("ping answered with the wrong node ID")
+	}
+}
+
+func TestFindNode(t *testing.T) {
+	nodes := startCluster(t, 8)
+	// the last node only knew the first, and learned the rest through
+	// find_node while bootstrapping
+	last := nodes[len(nodes)-1]
+	if got := last.table.len(); got < len(nodes)-2 {
+		t.Fatalf("routing table has %d nodes after bootstrapping, want most of the %d others", got, len(nodes)-1)
+	}
+	_, closest := last.lookup(nodes[3].ID(), false)
+	if len(closest) == 0 || closest[0].id != nodes[3].ID() {
+		t.Fatal("lookup of a node's ID did not find that node first")
+	}
+}
+
+func TestAnnounceAndGetPeers(t *testing.T) {
+	nodes := startCluster(t, 6)
+	infoHash := [20]byte{0xab, 0xcd}
+	if _, err := nodes[2].Announce(infoHash, 51413); err != nil {
+		t.Fatal(err)
+	}
+	peerList, err := nodes[5].GetPeers(infoHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(peerList, "127.0.0.1:51413") {
+		t.Fatalf("get_peers found %v, want the announced peer", peerList)
+	}
+	// port 0 announces the UDP port the announce came from
+	if _, err := nodes[3].Announce(infoHash, 0); err != nil {
+		t.Fatal(err)
+	}
+	peerList, _ = nodes[4].GetPeers(infoHash)
+	if !slices.Contains(peerList, nodes[3].Addr().String()) {
+		t.Fatalf("get_peers found %v, want the implied port of %s", peerList, nodes[3].Addr())
+	}
+}
+
+func TestAnnounceRejectsBadToken(t *testing.T) {
+	nodes := startCluster(t, 2)
+	infoHash := [20]byte{1}
+	_, err := nodes[0].query(nodes[1].Addr(), "announce_peer", &arguments{InfoHash: string(infoHash[:]), Port: 6881, Token: "forged"})
+	var krpcErr *Error
+	if !errors.As(err, &krpcErr) || krpcErr.Code != errorProtocol {
+		t.Fatalf("announce with a forged token = %v, want a protocol error", err)
+	}
+	if peerList := nodes[1].storedPeers(infoHash); len(peerList) != 0 {
+		t.Fatal("peer stored despite the bad token")
+	}
+
+	// a token handed out to one address is no good from another
+	resp, err := nodes[0].query(nodes[1].Addr(), "get_peers", &arguments{InfoHash: string(infoHash[:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes[1].validToken(resp.Token, net.IPv4(10, 0, 0, 1)) {
+		t.Fatal("token accepted from a different IP")
+	}
+	if _, err := nodes[0].query(nodes[1].Addr(), "announce_peer", &arguments{InfoHash: string(infoHash[:]), Port: 6881, Token: resp.Token}); err != nil {
+		t.Fatalf("announce with the issued token: %v", err)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	nodes := startCluster(t, 4)
+	statePath := filepath.Join(t.TempDir(), "dht.dat")
+	d := startNode(t, Config{StatePath: statePath}, nodes[0])
+	id := d.ID()
+	if d.table.len() < 3 {
+		t.Fatalf("only %d nodes known before saving", d.table.len())
+	}
+	// a node that stopped answering is not worth saving
+	bad := nodes[1].Addr()
+	for i := 0; i < maxFailures; i++ {
+		d.table.failed(bad)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := startNode(t, Config{StatePath: statePath})
+	if restarted.ID() != id {
+		t.Fatal("node ID not kept across runs")
+	}
+	saved := restarted.table.nodes()
+	if len(saved) != d.table.len()-1 {
+		t.Fatalf("%d nodes loaded, want the %d good ones", len(saved), d.table.len()-1)
+	}
+	for _, n := range saved {
+		if n.addr.String() == bad.String() {
+			t.Fatal("failing node was saved")
+		}
+	}
+}
+
+func TestDiscoverBootstrapsWhenSavedNodesAreGone(t *testing.T) {
+	nodes := startCluster(t, 4)
+	infoHash := [20]byte{9}
+	if _, err := nodes[1].Announce(infoHash, 51413); err != nil {
+		t.Fatal(err)
+	}
+
+	// the only saved contact has gone away since the last run
+	gone := startNode(t, Config{})
+	goneAddr := gone.Addr()
+	gone.Close()
+	d := startNode(t, Config{})
+	d.config.BootstrapNodes = []string{nodes[0].Addr().String()}
+	d.table.insert(node{id: [20]byte{0xff}, addr: goneAddr, lastSeen: time.Now()})
+
+	pool := peers.NewPool(infoHash, false)
+	if err := pool.Start(d); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(pool.Peers(), "127.0.0.1:51413") {
+		t.Fatalf("pool has %v, want the peer announced in the DHT", pool.Peers())
+	}
+}
+
+func TestDiscoverOnlyAnnouncesServedPorts(t *testing.T) {
+	nodes := startCluster(t, 3)
+	infoHash := [20]byte{7}
+	lookupOnly := startNode(t, Config{}, nodes[0])
+	if err := peers.NewPool(infoHash, false).Start(lookupOnly); err == nil {
+		t.Fatal("found peers for a torrent nobody announced")
+	}
+	for _, n := range nodes {
+		if len(n.storedPeers(infoHash)) != 0 {
+			t.Fatal("a node without a PeerPort announced the torrent")
+		}
+	}
+
+	serving := startNode(t, Config{PeerPort: 51413}, nodes[0])
+	serving.Discover(infoHash, peers.NewPool(infoHash, false))
+	stored := 0
+	for _, n := range nodes {
+		stored += len(n.storedPeers(infoHash))
+	}
+	if stored == 0 {
+		t.Fatal("a node with a PeerPort did not announce the torrent")
+	}
+}
@@ -0,0 +1,119 @@
+package dht
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// KRPC error codes (BEP 5).
+const (
+	errorGeneric  = 201
+	errorServer   = 202
+	errorProtocol = 203
+	errorMethod   = 204
+)
+
+// message is a KRPC query ("q"), response ("r") or error ("e").
+type message struct {
+	T string        `bencode:"t"`
+	Y string        `bencode:"y"`
+	Q string        `bencode:"q,omitempty"`
+	A *arguments    `bencode:"a,omitempty"`
+	R *response     `bencode:"r,omitempty"`
+	E []interface{} `bencode:"e,omitempty"`
+}
+
+type arguments struct {
+	ID          string `bencode:"id"`
+	Target      string `bencode:"target,omitempty"`
+	InfoHash    string `bencode:"info_hash,omitempty"`
+	Port        int    `bencode:"port,omitempty"`
+	Token       string `bencode:"token,omitempty"`
+	ImpliedPort int    `bencode:"implied_port,omitempty"`
+}
+
+type response struct {
+	ID    string `bencode:"id"`
+	Nodes string `bencode:"nodes,omitempty"`
+	// Nodes6 are IPv6 contacts, 38 bytes each (BEP 32).
+	Nodes6 string   `bencode:"nodes6,omitempty"`
+	Values []string `bencode:"values,omitempty"`
+	Token  string   `bencode:"token,omitempty"`
+}
+
+// Error is a KRPC error returned by a remote node.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dht error %d: %s", e.Code, e.Message)
+}
+
+func errorMessage(t string, code int, msg string) *message {
+	return &message{T: t, Y: "e", E: []interface{}{code, msg}}
+}
+
+// remoteError turns the "e" list of a message into an Error.
+func remoteError(e []interface{}) *Error {
+	err := &Error{Code: errorGeneric, Message: "malformed error"}
+	if len(e) >= 1 {
+		if code, ok := e[0].(int64); ok {
+			err.Code = int(code)
+		}
+	}
+	if len(e) >= 2 {
+		if msg, ok := e[1].(string); ok {
+			err.Message = msg
+		}
+	}
+	return err
+}
+
+// compactAddr packs an address as 4 or 16 IP bytes and a big-endian port.
+func compactAddr(addr *net.UDPAddr) []byte {
+	ip := addr.IP.To4()
+	if ip == nil {
+		ip = addr.IP.To16()
+	}
+	return binary.BigEndian.AppendUint16(append([]byte(nil), ip...), uint16(addr.Port))
+}
+
+func parseCompactAddr(data []byte) *net.UDPAddr {
+	size := len(data)
+	return &net.UDPAddr{
+		IP:   net.IP(append([]byte(nil), data[:size-2]...)),
+		Port: int(binary.BigEndian.Uint16(data[size-2:])),
+	}
+}
+
+// encodeNodes packs contacts into the compact "nodes" (26 bytes each) and
+// "nodes6" (38 bytes each) formats.
+func encodeNodes(nodes []node) (string, string) {
+	var v4, v6 []byte
+	for _, n := range nodes {
+		if n.addr.IP.To4() != nil {
+			v4 = append(append(v4, n.id[:]...), compactAddr(n.addr)...)
+		} else {
+			v6 = append(append(v6, n.id[:]...), compactAddr(n.addr)...)
+		}
+	}
+	return string(v4), string(v6)
+}
+
+// decodeNodes reads compact contacts of size 26 or 38, skipping any with
+// port 0.
+func decodeNodes(data string, size int) []node {
+	var nodes []node
+	for i := 0; i+size <= len(data); i += size {
+		n := node{addr: parseCompactAddr([]byte(data[i+20 : i+size]))}
+		copy(n.id[:], data[i:i+20])
+		if n.addr.Port == 0 {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
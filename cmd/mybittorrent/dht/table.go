@@ -0,0 +1,217 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/bits"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// bucketSize is K, the number of contacts kept per bucket.
+	bucketSize = 8
+	// questionableAfter is how long a silent node is still assumed good.
+	questionableAfter = 15 * time.Minute
+	// maxFailures unanswered queries in a row make a node bad.
+	maxFailures = 2
+)
+
+// node is a contact in the routing table.
+type node struct {
+	id       [20]byte
+	addr     *net.UDPAddr
+	lastSeen time.Time
+	failures int
+	// pinging is set while a questionable node is checked before it is
+	// replaced.
+	pinging bool
+}
+
+func distance(a, b [20]byte) [20]byte {
+	var d [20]byte
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// bucketIndex is the length of the prefix id shares with self, so bucket 0
+// holds the far half of the ID space and bucket 159 the closest node. It is
+// -1 for self.
+func bucketIndex(self, id [20]byte) int {
+	d := distance(self, id)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return -1
+}
+
+// randomIDInBucket returns an ID that falls in bucket index, for refreshing
+// it.
+func randomIDInBucket(self [20]byte, index int) [20]byte {
+	var id [20]byte
+	rand.Read(id[:])
+	for bit := 0; bit <= index; bit++ {
+		mask := byte(0x80) >> (bit % 8)
+		if bit == index {
+			id[bit/8] = id[bit/8]&^mask | ^self[bit/8]&mask
+		} else {
+			id[bit/8] = id[bit/8]&^mask | self[bit/8]&mask
+		}
+	}
+	return id
+}
+
+// table is the Kademlia routing table: one bucket per shared prefix length,
+// each holding up to bucketSize contacts, least recently seen first.
+type table struct {
+	mu          sync.Mutex
+	self        [20]byte
+	buckets     [160][]node
+	lastChanged [160]time.Time
+}
+
+func newTable(self [20]byte) *table {
+	return &table{self: self}
+}
+
+// insert adds or refreshes a contact that was just heard from. When its
+// bucket is full of good nodes it returns the least recently seen one if
+// that has become questionable; the caller pings it and calls replace if it
+// doesn't answer. Otherwise the new contact is dropped.
+func (t *table) insert(n node) (node, bool) {
+	index := bucketIndex(t.self, n.id)
+	if index < 0 {
+		return node{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	bucket := t.buckets[index]
+	for i, existing := range bucket {
+		if existing.id == n.id {
+			existing.addr = n.addr
+			existing.lastSeen = n.lastSeen
+			existing.failures = 0
+			t.buckets[index] = append(append(bucket[:i:i], bucket[i+1:]...), existing)
+			t.lastChanged[index] = time.Now()
+			return node{}, false
+		}
+	}
+	if len(bucket) < bucketSize {
+		t.buckets[index] = append(bucket, n)
+		t.lastChanged[index] = time.Now()
+		return node{}, false
+	}
+	for i, existing := range bucket {
+		if existing.failures >= maxFailures {
+			t.buckets[index] = append(append(bucket[:i:i], bucket[i+1:]...), n)
+			t.lastChanged[index] = time.Now()
+			return node{}, false
+		}
+	}
+	oldest := &bucket[0]
+	if oldest.pinging || time.Since(oldest.lastSeen) < questionableAfter {
+		return node{}, false
+	}
+	oldest.pinging = true
+	return *oldest, true
+}
+
+// replace swaps old, which failed to answer a ping, for n.
+func (t *table) replace(old, n node) {
+	t.remove(old.id)
+	t.insert(n)
+}
+
+func (t *table) remove(id [20]byte) {
+	index := bucketIndex(t.self, id)
+	if index < 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	bucket := t.buckets[index]
+	for i, existing := range bucket {
+		if existing.id == id {
+			t.buckets[index] = append(bucket[:i:i], bucket[i+1:]...)
+			return
+		}
+	}
+}
+
+// failed counts an unanswered query to addr; a node that fails maxFailures
+// times in a row is bad and is the first to be replaced.
+func (t *table) failed(addr *net.UDPAddr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for index := range t.buckets {
+		for i := range t.buckets[index] {
+			n := &t.buckets[index][i]
+			if n.addr.String() == addr.String() {
+				n.failures++
+				n.pinging = false
+			}
+		}
+	}
+}
+
+// closest returns up to count good contacts ordered by distance to target.
+func (t *table) closest(target [20]byte, count int) []node {
+	var nodes []node
+	for _, n := range t.nodes() {
+		if n.failures < maxFailures {
+			nodes = append(nodes, n)
+		}
+	}
+	sortByDistance(nodes, target)
+	if len(nodes) > count {
+		nodes = nodes[:count]
+	}
+	return nodes
+}
+
+func sortByDistance(nodes []node, target [20]byte) {
+	sort.Slice(nodes, func(i, j int) bool {
+		di, dj := distance(nodes[i].id, target), distance(nodes[j].id, target)
+		return bytes.Compare(di[:], dj[:]) < 0
+	})
+}
+
+func (t *table) nodes() []node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var nodes []node
+	for _, bucket := range t.buckets {
+		nodes = append(nodes, bucket...)
+	}
+	return nodes
+}
+
+func (t *table) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, bucket := range t.buckets {
+		n += len(bucket)
+	}
+	return n
+}
+
+// staleBuckets are the non-empty buckets nothing has changed in for
+// questionableAfter, which BEP 5 refreshes with a lookup.
+func (t *table) staleBuckets() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var stale []int
+	for index, bucket := range t.buckets {
+		if len(bucket) > 0 && time.Since(t.lastChanged[index]) > questionableAfter {
+			stale = append(stale, index)
+		}
+	}
+	return stale
+}
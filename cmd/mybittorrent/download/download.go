@@ -2,27 +2,41 @@
 package download
 
 import (
-	"bytes"
-	"crypto/sha1"
-	"encoding/binary"
+	"flag"
 	"fmt"
-	"io"
 	"net"
 	"os"
 	"strconv"
+	"time"
 
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/extensions/pex"
 	infoCommand "github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/info"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/queue"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/storage"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/tcp"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/verify"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/webseed"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/wire"
 )
 
 var (
-	isFailed   map[int]int
+	isFailed   = make(map[int]int)
 	maxRetries = 3
 )
 
+// BlockSize is the size of the requests a piece is split into; only the
+// last block of a piece can be shorter.
+const BlockSize = 16 * 1024
+
+// BlockCount is the number of requests needed for a piece of pieceLength
+// bytes.
+func BlockCount(pieceLength int) int {
+	return (pieceLength + BlockSize - 1) / BlockSize
+}
+
 func SavePieceToFile(pieceData []byte, downloadPath string) error {
 	file, err := os.OpenFile(downloadPath, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -45,105 +59,67 @@ func retry(pieceInd int) {
 	}
 }
 func HandleDownloadPiece(tcpConn *net.TCPConn, pieceInd int, totalBlocks int, pieceLength int, pieceReceivedIndex int, pieceData []byte, downloadPath string, Info *torrent.InfoData) []byte {
-	 
+	reader := wire.NewReader(tcpConn)
+	writer := wire.NewWriter(tcpConn)
+	if len(pieceData) < pieceLength {
+		pieceData = append(pieceData, make([]byte, pieceLength-len(pieceData))...)
+	}
+	received := make(map[int]bool)
 	for {
-		messageLength := make([]byte, 4)
-		_, err := io.ReadFull(tcpConn, messageLength)
+		tcpConn.SetReadDeadline(time.Now().Add(idleTimeout))
+		message, err := reader.ReadMessage()
 		if err != nil {
-			fmt.Println("error reading messageLength", err)
+			fmt.Println("error reading message", err)
 			retry(pieceInd)
 			return nil
 		}
-		length := binary.BigEndian.Uint32(messageLength)
-		if length == 0 {
+		switch message := message.(type) {
+		case wire.KeepAlive:
 			fmt.Println("Keep alive message received")
-			continue
-		}
-		messageID := make([]byte, 1)
-		_, err = io.ReadFull(tcpConn, messageID)
-		if err != nil {
-			fmt.Println("error reading messageID", err)
-			retry(pieceInd)
-			return nil
-		}
-		id := uint8(messageID[0])
-		switch id {
-		case 5:
+		case wire.Bitfield:
 			fmt.Println("Received bitfield message")
-			payload := make([]byte, length-1)
-			_, err := io.ReadFull(tcpConn, payload)
-			if err != nil {
-				fmt.Println("error reading bitfieldPayload", err)
-				retry(pieceInd)
-				return nil
-			}
-			interested := []byte{0, 0, 0, 1, 2}
-			_, err = tcpConn.Write(interested)
-			if err != nil {
+			if err := writer.WriteMessage(wire.Interested{}); err != nil {
 				fmt.Println("Error sending interested message:", err)
 				retry(pieceInd)
 				return nil
 			}
 
-		case 1:
+		case wire.Unchoke:
 			fmt.Println("Unchoke message received")
 			for i := 0; i < totalBlocks; i++ {
-				blockSize := 16 * 1024
-				if i == totalBlocks-1 {
-					blockSize = pieceLength % (16 * 1024)
-				}
-
-				request := make([]byte, 17)
-				binary.BigEndian.PutUint32(request[0:4], 13)                  // Message length (13 bytes)
-				request[4] = 6                                                // Message ID (request)
-				binary.BigEndian.PutUint32(request[5:9], uint32(pieceInd))    // Piece index
-				binary.BigEndian.PutUint32(request[9:13], uint32(i*16*1024))  // Begin offset
-				binary.BigEndian.PutUint32(request[13:17], uint32(blockSize)) // Block length
-
-				_, err = tcpConn.Write(request)
-				if err != nil {
+				begin := i * BlockSize
+				request := wire.Request{Index: uint32(pieceInd), Begin: uint32(begin), Length: uint32(min(BlockSize, pieceLength-begin))}
+				if err := writer.WriteMessage(request); err != nil {
 					fmt.Printf("Error sending request for block %d: %v\n", i+1, err)
 					retry(pieceInd)
 					return nil
 				}
 			}
-		case 7:
-			header := make([]byte, 8)
-			_, err := io.ReadFull(tcpConn, header)
-			if err != nil {
-				fmt.Println("Error reading piece header:", err)
+		case wire.Piece:
+			if int(message.Index) != pieceInd {
+				fmt.Printf("Wrong piece index received. Expected %d, got %d\n", pieceInd, message.Index)
 				retry(pieceInd)
 				return nil
 			}
-			index := binary.BigEndian.Uint32(header[0:4])
-			if int(index) != pieceInd {
-				fmt.Printf("Wrong piece index received. Expected %d, got %d\n", pieceInd, index)
-				retry(pieceInd)
-				return nil
-			}
-
-			blockSize := 16 * 1024
-			if pieceReceivedIndex == totalBlocks-1 {
-				blockSize = pieceLength % (16 * 1024)
-			}
 
-			dataBuff := make([]byte, blockSize)
-			_, err = io.ReadFull(tcpConn, dataBuff)
-			if err != nil {
-				fmt.Printf("Error reading piece data (block %d): %v\n", pieceReceivedIndex, err)
+			// blocks can arrive in any order, so each goes to its own offset,
+			// and must be exactly the size that was requested there
+			begin := int(message.Begin)
+			if begin%BlockSize != 0 || begin >= pieceLength || len(message.Block) != min(BlockSize, pieceLength-begin) {
+				fmt.Printf("Block at offset %d of %d bytes does not fit piece %d\n", begin, len(message.Block), pieceInd)
 				retry(pieceInd)
 				return nil
 			}
-
-			pieceData = append(pieceData, dataBuff...)
+			if received[begin] {
+				continue
+			}
+			received[begin] = true
+			copy(pieceData[begin:], message.Block)
 			pieceReceivedIndex++
-			fmt.Printf("Received block %d of %d (size: %d bytes)\n", pieceReceivedIndex, totalBlocks, blockSize)
+			fmt.Printf("Received block %d of %d (size: %d bytes)\n", pieceReceivedIndex, totalBlocks, len(message.Block))
 
 			if pieceReceivedIndex == totalBlocks {
-				receivedPieceHash := sha1.Sum(pieceData)
-				expectedHash := Info.Pieces[pieceInd*20 : (pieceInd+1)*20]
-
-				if bytes.Equal(receivedPieceHash[:], []byte(expectedHash)) {
+				if Info.VerifyPiece(pieceInd, pieceData) {
 					fmt.Println("Piece hash verified successfully")
 					if downloadPath != "" {
 						err := SavePieceToFile(pieceData, downloadPath)
@@ -156,70 +132,257 @@ func HandleDownloadPiece(tcpConn *net.TCPConn, pieceInd int, totalBlocks int, pi
 					return pieceData
 				} else {
 					fmt.Println("Piece hash verification failed")
+					retry(pieceInd)
 					return nil
 				}
 			}
+		case wire.Extended:
+			// peer exchange keeps running while pieces download
+			exchange := pex.ForConn(tcpConn)
+			if exchange == nil {
+				continue
+			}
+			switch message.ExtendedID {
+			case 0:
+				var handshake extensionHandshake
+				if err := bencode.Unmarshal(message.Payload, &handshake); err == nil {
+					exchange.SetRemoteID(handshake.M[pex.ExtensionName])
+				}
+			case pex.LocalID:
+				if err := exchange.Handle(message.Payload); err != nil {
+					fmt.Println(err)
+				}
+			}
 		}
 	}
 }
-func DownloadPiece(bencodedValue string, downloadPath string, pieceIndex string) []byte {
+func DownloadPiece(bencodedValue string, downloadPath string, pieceIndex string, opts Options) []byte {
 	metadata, err := infoCommand.LoadTorrentFile(bencodedValue)
 	if err != nil {
 		fmt.Println(err)
 		return nil
 	}
-	peers := peers.PeersCommand(bencodedValue)
-	pieceData := make([]byte, 0)
 	pieceInd, _ := strconv.Atoi(pieceIndex)
-	tcpConn := tcp.ConnectTCP(bencodedValue, peers[pieceInd%len(peers)])
-
-	pieceLength := metadata.Info.Piece_length
-	//total number of pieces
-	// eg: len(metadata.Info.Pieces)/20 // Number of pieces
-	// 60/20 = 3 pieces
-
-	// len(metadata.Info.Pieces)/20 - 1 // Index of last piece
-	// 3-1 = 2 (pieces are 0-indexed))
-	if pieceInd == len(metadata.Info.Pieces)/20-1 {
-		lastPieceLength := metadata.Info.Length % metadata.Info.Piece_length
-		if lastPieceLength > 0 {
-			pieceLength = lastPieceLength
+	swarm, err := joinSwarm(metadata, int64(metadata.Info.TotalLength()), nil, nil, opts)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	defer swarm.Close()
+	pieceData := downloadPieceFromPool(swarm.pool, &metadata.Info, pieceInd, downloadPath)
+	if pieceData != nil {
+		swarm.AddDownloaded(int64(len(pieceData)))
+	}
+	return pieceData
+}
+
+func downloadPieceFromPool(pool *peers.Pool, info *torrent.InfoData, pieceInd int, downloadPath string) []byte {
+	peerList := pool.Peers()
+	if len(peerList) == 0 {
+		fmt.Println("Error fetching peers or no peers available")
+		return nil
+	}
+	return DownloadPieceFromPeer(peerList[pieceInd%len(peerList)], pool, info, pieceInd, downloadPath)
+}
+
+// DownloadPieceFromPeer connects to peerAddr for the pool's torrent and
+// downloads one piece. Peers that support it exchange peers with the pool
+// for as long as the connection lasts.
+func DownloadPieceFromPeer(peerAddr string, pool *peers.Pool, info *torrent.InfoData, pieceInd int, downloadPath string) []byte {
+	pieceData := make([]byte, 0)
+	tcpConn, peer, err := tcp.DialPeer(peerAddr, pool.InfoHash(), tcp.DefaultReserved)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	fmt.Println("Peer ID:", peer.HexID())
+	if peer.SupportsExtensions() {
+		if exchange := startPEX(tcpConn, pool); exchange != nil {
+			defer exchange.Close()
 		}
 	}
-	totalBlocks := (pieceLength)/(16*1024) + 1
+
+	// only the last piece can be shorter than the piece length, and for
+	// multi-file torrents it is measured against the sum of all file lengths
+	pieceLength := info.PieceSize(pieceInd)
+	totalBlocks := BlockCount(pieceLength)
 	fmt.Println("total blocks", totalBlocks)
 
 	pieceReceivedIndex := 0
 	defer tcpConn.Close()
-	return HandleDownloadPiece(tcpConn, pieceInd, totalBlocks, pieceLength, pieceReceivedIndex, pieceData, downloadPath, &metadata.Info)
+	return HandleDownloadPiece(tcpConn, pieceInd, totalBlocks, pieceLength, pieceReceivedIndex, pieceData, downloadPath, info)
+
+}
 
+// startPEX starts peer exchange on a new connection and offers ut_pex in an
+// extension handshake. Private torrents get neither.
+func startPEX(tcpConn *net.TCPConn, pool *peers.Pool) *pex.Exchange {
+	exchange, err := pex.Start(tcpConn, pool)
+	if err != nil {
+		return nil
+	}
+	payload, err := bencode.Marshal(extensionHandshake{M: map[string]int{pex.ExtensionName: pex.LocalID}})
+	if err == nil {
+		err = wire.NewWriter(tcpConn).WriteMessage(wire.Extended{ExtendedID: 0, Payload: payload})
+	}
+	if err != nil {
+		fmt.Println("Error sending extension handshake:", err)
+		exchange.Close()
+		return nil
+	}
+	return exchange
 }
+
+// downloadPieceFromWebSeeds tries each web seed in turn, starting at a
+// different one per piece to spread the load across mirrors.
+func downloadPieceFromWebSeeds(seeds []string, info *torrent.InfoData, pieceIndex int) []byte {
+	for i := range seeds {
+		seedURL := seeds[(pieceIndex+i)%len(seeds)]
+		pieceData, err := webseed.FetchPiece(seedURL, info, pieceIndex)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println("Piece", pieceIndex, "downloaded from web seed", seedURL)
+		return pieceData
+	}
+	return nil
+}
+
+// downloadPieceFromSources spreads pieces over peers and web seeds alike,
+// so the mirrors carry their share of the download rather than only
+// covering for failed peers. Whichever kind a piece isn't assigned to
+// stands in when its first choice fails.
+func downloadPieceFromSources(pool *peers.Pool, seeds []string, info *torrent.InfoData, pieceIndex int) []byte {
+	peerCount := len(pool.Peers())
+	fromSeeds := len(seeds) > 0 && pieceIndex%(peerCount+len(seeds)) >= peerCount
+	var pieceData []byte
+	if fromSeeds {
+		pieceData = downloadPieceFromWebSeeds(seeds, info, pieceIndex)
+		if pieceData == nil && peerCount > 0 {
+			pieceData = downloadPieceFromPool(pool, info, pieceIndex, "")
+		}
+		return pieceData
+	}
+	if peerCount > 0 {
+		pieceData = downloadPieceFromPool(pool, info, pieceIndex, "")
+	}
+	if pieceData == nil && len(seeds) > 0 {
+		pieceData = downloadPieceFromWebSeeds(seeds, info, pieceIndex)
+	}
+	return pieceData
+}
+
 func AddPiecesToQueue(totalPieces int) {
 	for i := 0; i < totalPieces; i++ {
 		queue.Push(i)
 	}
 }
-func DownloadFile(bencodedValue string, downloadPath string) {
+func DownloadFile(bencodedValue string, downloadPath string, opts Options) {
 	metadata, err := infoCommand.LoadTorrentFile(bencodedValue)
 	if err != nil {
 		fmt.Println("error opening file", bencodedValue)
 		return
 	}
-	totalPieces := len(metadata.Info.Pieces) / 20
-	file := make([]byte, 0)
-	AddPiecesToQueue(totalPieces)
+	layout, err := storage.NewLayout(&metadata.Info, downloadPath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := layout.Create(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	seeds := webseed.Sources(metadata)
+	completed := make(map[int]bool)
+	// adopt whatever is already on disk, e.g. from an interrupted run or
+	// another client, and only fetch the pieces that don't check out
+	for pieceIndex, status := range verify.CheckPieces(&metadata.Info, layout) {
+		if status == verify.PieceValid {
+			completed[pieceIndex] = true
+		}
+	}
+	left := int64(metadata.Info.TotalLength())
+	for pieceIndex := range completed {
+		left -= int64(metadata.Info.PieceSize(pieceIndex))
+	}
+	if len(completed) > 0 {
+		fmt.Printf("Found %d of %d pieces already on disk\n", len(completed), metadata.Info.PieceCount())
+	}
+
+	swarm, err := joinSwarm(metadata, left, layout, completed, opts)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer swarm.Close()
+	pool := swarm.pool
+
+	AddPiecesToQueue(metadata.Info.PieceCount())
 	for !queue.Empty() {
 		pieceIndex := queue.Front()
 		queue.Pop()
-		pieceData := DownloadPiece(bencodedValue, "", strconv.Itoa(pieceIndex))
-		file = append(file, pieceData...)
-
+		if completed[pieceIndex] {
+			continue
+		}
+		pieceData := downloadPieceFromSources(pool, seeds, &metadata.Info, pieceIndex)
+		if pieceData == nil {
+			continue
+		}
+		if err := layout.WritePiece(pieceIndex, pieceData); err != nil {
+			fmt.Println("error saving to ", downloadPath, err)
+			return
+		}
+		completed[pieceIndex] = true
+		swarm.Have(pieceIndex)
+		swarm.AddDownloaded(int64(len(pieceData)))
 	}
-	err = SavePieceToFile(file, downloadPath)
-	if err != nil {
-		fmt.Println("error saving to ", downloadPath)
+	if len(completed) != metadata.Info.PieceCount() {
+		fmt.Printf("Download incomplete: %d of %d pieces\n", len(completed), metadata.Info.PieceCount())
 		return
 	}
+	if err := swarm.Completed(); err != nil {
+		fmt.Println("Error announcing completion:", err)
+	}
 	fmt.Println("File Saved successfully")
 
 }
+
+// downloadFlags parses the options shared by download and download_piece.
+func downloadFlags(name string, usage string, args []string) (*flag.FlagSet, string, Options, bool) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	output := flags.String("o", "", "where to save the download")
+	noLSD := flags.Bool("no-lsd", false, "don't announce the download on the local network (BEP 14)")
+	flags.Usage = func() {
+		fmt.Println("Usage:", usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, "", Options{}, false
+	}
+	return flags, *output, Options{NoLSD: *noLSD}, true
+}
+
+func DownloadPieceCommand(args []string) {
+	flags, output, opts, ok := downloadFlags("download_piece", "download_piece [-no-lsd] -o <output file> <torrent file> <piece index>", args)
+	if !ok {
+		return
+	}
+	if flags.NArg() != 2 || output == "" {
+		flags.Usage()
+		return
+	}
+	DownloadPiece(flags.Arg(0), output, flags.Arg(1), opts)
+}
+
+func DownloadCommand(args []string) {
+	flags, output, opts, ok := downloadFlags("download", "download [-no-lsd] -o <output path> <torrent file>", args)
+	if !ok {
+		return
+	}
+	if flags.NArg() != 1 || output == "" {
+		flags.Usage()
+		return
+	}
+	DownloadFile(flags.Arg(0), output, opts)
+}
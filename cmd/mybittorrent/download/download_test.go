@@ -0,0 +1,160 @@
+package download
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/extensions/pex"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/tcp"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/wire"
+)
+
+// remotePEXID is the id the fake peer assigns to ut_pex.
+const remotePEXID = 3
+
+// fakePeer serves one piece to one connection. It supports ut_pex, tells
+// the client about pexPeer, and records the extended messages it is sent.
+type fakePeer struct {
+	listener *net.TCPListener
+	infoHash [20]byte
+	piece    []byte
+	pexPeer  string
+	extended chan wire.Extended
+	// trim is how many bytes are cut off the end of every block sent.
+	trim int
+}
+
+func startFakePeer(t *testing.T, infoHash [20]byte, piece []byte) *fakePeer {
+	t.Helper()
+	f := listenFakePeer(t, infoHash, piece)
+	go f.serve()
+	return f
+}
+
+// listenFakePeer is startFakePeer without starting to serve, for tests that
+// change how the peer behaves first.
+func listenFakePeer(t *testing.T, infoHash [20]byte, piece []byte) *fakePeer {
+	t.Helper()
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return &fakePeer{listener: listener, infoHash: infoHash, piece: piece, pexPeer: "10.1.1.1:6881", extended: make(chan wire.Extended, 10)}
+}
+
+func (f *fakePeer) serve() {
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := tcp.CompleteHandshake(conn, f.infoHash, tcp.ExtensionProtocol); err != nil {
+		return
+	}
+	reader, writer := wire.NewReader(conn), wire.NewWriter(conn)
+	handshake, _ := bencode.Marshal(extensionHandshake{M: map[string]int{pex.ExtensionName: remotePEXID}})
+	writer.WriteMessage(wire.Extended{ExtendedID: 0, Payload: handshake})
+	writer.WriteMessage(wire.Bitfield{Bits: []byte{0x80}})
+	for {
+		message, err := reader.ReadMessage()
+		if err != nil {
+			return
+		}
+		switch message := message.(type) {
+		case wire.Extended:
+			f.extended <- message
+		case wire.Interested:
+			added, _ := bencode.Marshal(pex.NewMessage([]pex.Peer{{Addr: f.pexPeer}}, nil))
+			writer.WriteMessage(wire.Extended{ExtendedID: pex.LocalID, Payload: added})
+			writer.WriteMessage(wire.Unchoke{})
+		case wire.Request:
+			block := f.piece[message.Begin : message.Begin+message.Length-uint32(f.trim)]
+			writer.WriteMessage(wire.Piece{Index: message.Index, Begin: message.Begin, Block: block})
+		}
+	}
+}
+
+func pieceTorrent(piece []byte) *torrent.InfoData {
+	hash := sha1.Sum(piece)
+	return &torrent.InfoData{Name: "data", Length: len(piece), Piece_length: len(piece), Pieces: string(hash[:])}
+}
+
+func TestDownloadPieceExchangesPeers(t *testing.T) {
+	piece := make([]byte, 2*BlockSize)
+	rand.Read(piece)
+	infoHash := [20]byte{5}
+	peer := startFakePeer(t, infoHash, piece)
+	pool := peers.NewPool(infoHash, false)
+	pool.Add(peers.SourceTracker, peer.listener.Addr().String(), "10.2.2.2:6881")
+
+	got := DownloadPieceFromPeer(peer.listener.Addr().String(), pool, pieceTorrent(piece), 0, "")
+	if !bytes.Equal(got, piece) {
+		t.Fatal("piece not downloaded")
+	}
+	if src, ok := pool.SourceOf(peer.pexPeer); !ok || src != peers.SourcePEX {
+		t.Fatalf("pool has %v, want the peer learned through PEX", pool.Peers())
+	}
+
+	var handshake extensionHandshake
+	bencode.Unmarshal((<-peer.extended).Payload, &handshake)
+	if handshake.M[pex.ExtensionName] != pex.LocalID {
+		t.Fatalf("extension handshake %v does not offer ut_pex", handshake.M)
+	}
+	message := <-peer.extended
+	var m pex.Message
+	bencode.Unmarshal(message.Payload, &m)
+	added := m.AddedPeers()
+	if message.ExtendedID != remotePEXID || len(added) != 1 || added[0].Addr != "10.2.2.2:6881" {
+		t.Fatalf("sent ut_pex %+v with id %d, want the other tracker peer only", added, message.ExtendedID)
+	}
+}
+
+func TestDownloadPieceWithoutPEXForPrivateTorrents(t *testing.T) {
+	piece := make([]byte, BlockSize+100)
+	rand.Read(piece)
+	infoHash := [20]byte{6}
+	peer := startFakePeer(t, infoHash, piece)
+	pool := peers.NewPool(infoHash, true)
+	pool.Add(peers.SourceTracker, peer.listener.Addr().String(), "10.2.2.2:6881")
+
+	got := DownloadPieceFromPeer(peer.listener.Addr().String(), pool, pieceTorrent(piece), 0, "")
+	if !bytes.Equal(got, piece) {
+		t.Fatal("piece not downloaded")
+	}
+	if slices.Contains(pool.Peers(), peer.pexPeer) {
+		t.Fatal("private torrent took a peer from PEX")
+	}
+	select {
+	case message := <-peer.extended:
+		t.Fatalf("sent extended message %d for a private torrent", message.ExtendedID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDownloadPieceRejectsShortBlocks(t *testing.T) {
+	// the blocks end in zeroes, so a piece with the missing bytes left as
+	// zeroes would still pass the hash check
+	piece := make([]byte, BlockSize+100)
+	rand.Read(piece)
+	piece[BlockSize-1], piece[len(piece)-1] = 0, 0
+	infoHash := [20]byte{7}
+	peer := listenFakePeer(t, infoHash, piece)
+	peer.trim = 1
+	go peer.serve()
+	pool := peers.NewPool(infoHash, false)
+	pool.Add(peers.SourceTracker, peer.listener.Addr().String())
+
+	if got := DownloadPieceFromPeer(peer.listener.Addr().String(), pool, pieceTorrent(piece), 0, ""); got != nil {
+		t.Fatal("piece accepted from blocks shorter than requested")
+	}
+}
@@ -0,0 +1,206 @@
+package download
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/tcp"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/wire"
+)
+
+// maxRequestLength is the largest block a peer may ask for; bigger requests
+// close the connection, as they do in other clients.
+const maxRequestLength = 128 * 1024
+
+// handshakeTimeout bounds the handshake of a peer that connected to us.
+const handshakeTimeout = 10 * time.Second
+
+// idleTimeout drops peers that have gone quiet, incoming or not; peers send
+// a keep-alive every two minutes.
+const idleTimeout = 3 * time.Minute
+
+// extensionHandshake is the part of a BEP 10 handshake the seeder uses: p is
+// the port the sender accepts connections on.
+type extensionHandshake struct {
+	M map[string]int `bencode:"m"`
+	P int            `bencode:"p,omitempty"`
+}
+
+// seeder answers peers that connect to the port the swarm announces. Each
+// one joins the pool as an incoming peer, and is sent the pieces it asks
+// for out of those already verified on disk.
+type seeder struct {
+	listener *net.TCPListener
+	info     *torrent.InfoData
+	// layout is nil when the download isn't kept on disk, e.g. a single
+	// piece; such a seeder has nothing to upload.
+	layout   *storage.Layout
+	pool     *peers.Pool
+	uploaded func(n int64)
+
+	mu     sync.Mutex
+	have   map[int]bool
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+// listenForPeers accepts peers on the usual port, or any free one when
+// another client already has it.
+func listenForPeers() (*net.TCPListener, error) {
+	listener, err := tcp.Listen(peers.DefaultPort)
+	if err != nil {
+		listener, err = tcp.Listen(0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listening for peers: %v", err)
+	}
+	return listener, nil
+}
+
+func (s *seeder) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Have makes a verified piece available to peers.
+func (s *seeder) Have(pieceIndex int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.have[pieceIndex] = true
+}
+
+func (s *seeder) has(pieceIndex int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.layout != nil && s.have[pieceIndex]
+}
+
+func (s *seeder) bitfield() (wire.Bitfield, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bits := make([]byte, (s.info.PieceCount()+7)/8)
+	for pieceIndex := range s.have {
+		bits[pieceIndex/8] |= 0x80 >> (pieceIndex % 8)
+	}
+	return wire.Bitfield{Bits: bits}, s.layout != nil && len(s.have) > 0
+}
+
+func (s *seeder) run() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go func() {
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			if err := s.serve(conn); err != nil {
+				fmt.Println("Incoming peer", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Close stops accepting peers and drops the connected ones.
+func (s *seeder) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *seeder) serve(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	peer, err := tcp.CompleteHandshake(conn, s.pool.InfoHash(), tcp.DefaultReserved)
+	if err != nil {
+		return err
+	}
+	writer := wire.NewWriter(conn)
+	if peer.SupportsExtensions() {
+		// tells the peer it may reach us on this port
+		payload, err := bencode.Marshal(extensionHandshake{M: map[string]int{}, P: s.Port()})
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteMessage(wire.Extended{ExtendedID: 0, Payload: payload}); err != nil {
+			return err
+		}
+	}
+	if bitfield, ok := s.bitfield(); ok {
+		if err := writer.WriteMessage(bitfield); err != nil {
+			return err
+		}
+	}
+
+	reader := wire.NewReader(conn)
+	cachedIndex := -1
+	var cachedPiece []byte
+	for {
+		conn.SetDeadline(time.Now().Add(idleTimeout))
+		message, err := reader.ReadMessage()
+		if err != nil {
+			return err
+		}
+		switch message := message.(type) {
+		case wire.Interested:
+			if err := writer.WriteMessage(wire.Unchoke{}); err != nil {
+				return err
+			}
+		case wire.Request:
+			index, begin, length := int(message.Index), int(message.Begin), int(message.Length)
+			if length > maxRequestLength {
+				return fmt.Errorf("requested %d bytes at once", length)
+			}
+			if index >= s.info.PieceCount() || !s.has(index) || begin+length > s.info.PieceSize(index) {
+				continue
+			}
+			if index != cachedIndex {
+				if cachedPiece, err = s.layout.ReadPiece(index); err != nil {
+					return err
+				}
+				cachedIndex = index
+			}
+			block := cachedPiece[begin : begin+length]
+			if err := writer.WriteMessage(wire.Piece{Index: message.Index, Begin: message.Begin, Block: block}); err != nil {
+				return err
+			}
+			s.uploaded(int64(length))
+		case wire.Extended:
+			if message.ExtendedID != 0 {
+				continue
+			}
+			// the address the peer connected from has a throwaway port, so
+			// it is only worth dialing back once the peer names its own
+			var handshake extensionHandshake
+			if bencode.Unmarshal(message.Payload, &handshake) != nil || handshake.P <= 0 || handshake.P > 65535 {
+				continue
+			}
+			host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+			if err != nil {
+				continue
+			}
+			s.pool.Add(peers.SourceIncoming, net.JoinHostPort(host, strconv.Itoa(handshake.P)))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package download
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/tcp"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/wire"
+)
+
+// startSeeder serves a two piece torrent of which only the first piece is on
+// disk, and returns the piece data.
+func startSeeder(t *testing.T) (*seeder, []byte, *atomic.Int64) {
+	t.Helper()
+	info := &torrent.InfoData{Name: "data", Length: 3 * BlockSize, Piece_length: 2 * BlockSize}
+	layout, err := storage.NewLayout(info, filepath.Join(t.TempDir(), "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := layout.Create(); err != nil {
+		t.Fatal(err)
+	}
+	piece := make([]byte, 2*BlockSize)
+	rand.Read(piece)
+	if err := layout.WritePiece(0, piece); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uploaded atomic.Int64
+	s := &seeder{
+		listener: listener,
+		info:     info,
+		layout:   layout,
+		pool:     peers.NewPool([20]byte{7}, true),
+		uploaded: func(n int64) { uploaded.Add(n) },
+		have:     map[int]bool{0: true},
+		conns:    make(map[net.Conn]bool),
+	}
+	go s.run()
+	t.Cleanup(s.Close)
+	return s, piece, &uploaded
+}
+
+func TestSeederServesPieces(t *testing.T) {
+	s, piece, uploaded := startSeeder(t)
+	conn, _, err := tcp.DialPeer(s.listener.Addr().String(), [20]byte{7}, tcp.DefaultReserved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader, writer := wire.NewReader(conn), wire.NewWriter(conn)
+
+	// our extension handshake names the port we can be reached on
+	payload, _ := bencode.Marshal(extensionHandshake{M: map[string]int{}, P: 51413})
+	writer.WriteMessage(wire.Extended{ExtendedID: 0, Payload: payload})
+	writer.WriteMessage(wire.Interested{})
+	// the missing piece is ignored, the one on disk is answered
+	writer.WriteMessage(wire.Request{Index: 1, Begin: 0, Length: BlockSize})
+	writer.WriteMessage(wire.Request{Index: 0, Begin: BlockSize, Length: BlockSize})
+
+	var sawPort, sawBitfield, sawUnchoke bool
+	for {
+		message, err := reader.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch message := message.(type) {
+		case wire.Extended:
+			var handshake extensionHandshake
+			bencode.Unmarshal(message.Payload, &handshake)
+			sawPort = handshake.P == s.Port()
+		case wire.Bitfield:
+			sawBitfield = bytes.Equal(message.Bits, []byte{0x80})
+		case wire.Unchoke:
+			sawUnchoke = true
+		case wire.Piece:
+			if message.Index != 0 || message.Begin != BlockSize || !bytes.Equal(message.Block, piece[BlockSize:]) {
+				t.Fatalf("got block %d/%d, want the second block of piece 0", message.Index, message.Begin)
+			}
+			if !sawPort || !sawBitfield || !sawUnchoke {
+				t.Fatalf("port %v, bitfield %v, unchoke %v before the block", sawPort, sawBitfield, sawUnchoke)
+			}
+			if uploaded.Load() != BlockSize {
+				t.Fatalf("%d bytes counted as uploaded", uploaded.Load())
+			}
+			want := net.JoinHostPort("127.0.0.1", strconv.Itoa(51413))
+			if src, ok := s.pool.SourceOf(want); !ok || src != peers.SourceIncoming {
+				t.Fatalf("pool has %v, want %s as an incoming peer", s.pool.Peers(), want)
+			}
+			return
+		}
+	}
+}
+
+func TestSeederRefusesOtherTorrents(t *testing.T) {
+	s, _, _ := startSeeder(t)
+	if _, _, err := tcp.DialPeer(s.listener.Addr().String(), [20]byte{8}, tcp.DefaultReserved); err == nil {
+		t.Fatal("handshake for another info hash succeeded")
+	}
+}
@@ -0,0 +1,130 @@
+package download
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	infoCommand "github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/info"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/lsd"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+// Options are the settings of a download beyond what to download.
+type Options struct {
+	// NoLSD keeps the download off local service discovery, so it isn't
+	// announced on the local network.
+	NoLSD bool
+}
+
+// swarm is the peer side of a download: the pool every peer source feeds,
+// the announcer keeping the trackers informed when they answered, and the
+// seeder serving the port both advertise.
+type swarm struct {
+	pool      *peers.Pool
+	announcer *peers.Announcer
+	seeder    *seeder
+
+	closers   []func()
+	closeOnce sync.Once
+	signals   chan os.Signal
+}
+
+// joinSwarm announces the torrent, with left bytes still to download, and
+// starts the other peer sources on its pool. The trackers hear about the
+// download for as long as it runs, including when it is interrupted. Peers
+// that connect are served the pieces in have from layout, which may be nil.
+func joinSwarm(metadata *torrent.Torrent, left int64, layout *storage.Layout, have map[int]bool, opts Options) (*swarm, error) {
+	listener, err := listenForPeers()
+	if err != nil {
+		return nil, err
+	}
+	s := &swarm{}
+	s.seeder = &seeder{listener: listener, info: &metadata.Info, layout: layout, uploaded: s.AddUploaded, have: make(map[int]bool), conns: make(map[net.Conn]bool)}
+	for pieceIndex := range have {
+		s.seeder.Have(pieceIndex)
+	}
+	port := s.seeder.Port()
+
+	announcer, err := peers.StartAnnouncer(metadata, left, port)
+	if err != nil {
+		fmt.Println("Error announcing to trackers:", err)
+		hashes, err := infoCommand.SwarmHashes(metadata)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		s.pool = peers.NewPool(hashes[0], metadata.Info.IsPrivate())
+	} else {
+		s.announcer = announcer
+		s.pool = announcer.Pool()
+		s.closers = append(s.closers, func() { announcer.Stop() })
+	}
+	s.seeder.pool = s.pool
+	go s.seeder.run()
+	s.closers = append(s.closers, s.seeder.Close)
+
+	// peers on the same network can serve the torrent without the trackers
+	if !opts.NoLSD {
+		if service, err := lsd.Start(port, nil); err == nil {
+			s.closers = append(s.closers, service.Close)
+			if err := s.pool.Start(service); err != nil && err != peers.ErrPrivateTorrent {
+				fmt.Println(err)
+			}
+		} else {
+			fmt.Println(err)
+		}
+	}
+
+	s.signals = make(chan os.Signal, 1)
+	signal.Notify(s.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-s.signals; ok {
+			s.Close()
+			os.Exit(1)
+		}
+	}()
+	return s, nil
+}
+
+// AddDownloaded counts n bytes received towards the trackers' statistics.
+func (s *swarm) AddDownloaded(n int64) {
+	if s.announcer != nil {
+		s.announcer.AddDownloaded(n)
+	}
+}
+
+// AddUploaded counts n bytes sent to peers towards the trackers' statistics.
+func (s *swarm) AddUploaded(n int64) {
+	if s.announcer != nil {
+		s.announcer.AddUploaded(n)
+	}
+}
+
+// Have offers a piece that was just verified and written to peers.
+func (s *swarm) Have(pieceIndex int) {
+	s.seeder.Have(pieceIndex)
+}
+
+// Completed tells the trackers the download has finished.
+func (s *swarm) Completed() error {
+	if s.announcer == nil {
+		return nil
+	}
+	return s.announcer.Completed()
+}
+
+// Close stops every peer source, last started first, and announces stopped.
+func (s *swarm) Close() {
+	s.closeOnce.Do(func() {
+		signal.Stop(s.signals)
+		for i := len(s.closers) - 1; i >= 0; i-- {
+			s.closers[i]()
+		}
+	})
+}
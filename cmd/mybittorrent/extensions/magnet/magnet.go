@@ -2,169 +2,233 @@ package magnet
 
 import (
 	"bytes"
-	"encoding/binary"
+	"encoding/base32"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
 	"net"
 	"net/url"
-	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/dht"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/download"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/extensions/pex"
 	infoCommand "github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/info"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/queue"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/storage"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/tcp"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
-	"github.com/jackpal/bencode-go"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/wire"
 )
 
+// metadataLocalID is the extended message id peers use to send us
+// ut_metadata.
+const metadataLocalID = 20
+
 type extensionMsg struct {
-	M map[string]interface{} `bencode:"m"`
+	M map[string]int `bencode:"m"`
 }
 type requestMsgPayload struct {
 	Msg_type int `bencode:"msg_type"`
 	Piece    int `bencode:"piece"`
 }
 
-func ParseMagnetLinks(magnetLink string) (string, string) {
+// ParseMagnetLinks returns the tracker URLs of a magnet link and its info
+// hash as hex. Base32 info hashes are converted.
+func ParseMagnetLinks(magnetLink string) ([]string, string, error) {
 	infoHashPattern := `xt=urn:btih:([a-fA-F0-9]{40}|[a-zA-Z2-7]{32})`
 	trackerPattern := `tr=([^&]+)`
-	trackerURL := ""
+	var trackerURLs []string
 
 	reTracker := regexp.MustCompile(trackerPattern)
-	trackerURLs := reTracker.FindAllStringSubmatch(magnetLink, -1)
-	for _, match := range trackerURLs {
+	matches := reTracker.FindAllStringSubmatch(magnetLink, -1)
+	for _, match := range matches {
 		decodedURL, err := url.QueryUnescape(match[1])
 		if err != nil {
-			fmt.Println(err)
-			return "", ""
+			return nil, "", fmt.Errorf("error decoding tracker URL: %v", err)
 		}
-		trackerURL = decodedURL
-		fmt.Println("Tracker URL:", trackerURL)
+		trackerURLs = append(trackerURLs, decodedURL)
+		fmt.Println("Tracker URL:", decodedURL)
 	}
 
 	reInfoHash := regexp.MustCompile(infoHashPattern)
 	infoHash := reInfoHash.FindStringSubmatch(magnetLink)
-	if len(infoHash) > 1 {
-		fmt.Println("Info Hash:", infoHash[1])
+	if len(infoHash) < 2 {
+		return nil, "", errors.New("magnet link has no btih info hash")
+	}
+	hexInfoHash := strings.ToLower(infoHash[1])
+	if len(hexInfoHash) == 32 {
+		decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(infoHash[1]))
+		if err != nil {
+			return nil, "", fmt.Errorf("error decoding base32 info hash: %v", err)
+		}
+		hexInfoHash = hex.EncodeToString(decoded)
 	}
-	return trackerURL, infoHash[1]
+	fmt.Println("Info Hash:", hexInfoHash)
+	return trackerURLs, hexInfoHash, nil
 }
 
-func MagnetHandshake(magnetLink string) (*net.TCPConn, *torrent.InfoData) {
-	trackerURL, infoHash := ParseMagnetLinks(magnetLink)
-	byteInfoHash, _ := hex.DecodeString(infoHash)
+// MagnetHandshake finds peers for a magnet link, connects to the first and
+// fetches the torrent's metadata from it. It returns the connection, the
+// pool of peers found, which PEX keeps adding to, and the metadata; all nil
+// on failure.
+func MagnetHandshake(magnetLink string, dhtConfig dht.Config) (*net.TCPConn, *peers.Pool, *torrent.InfoData) {
+	trackerURLs, infoHash, err := ParseMagnetLinks(magnetLink)
+	if err != nil {
+		fmt.Println(err)
+		return nil, nil, nil
+	}
 	var infoHashArray [20]byte
-	copy(infoHashArray[:], byteInfoHash)
+	hex.Decode(infoHashArray[:], []byte(infoHash))
 
-	peerList, err := peers.FetchPeersFromTracker(trackerURL, infoHashArray, nil)
-	if err != nil || len(peerList) == 0 {
+	// every tr= parameter is its own tier, so all of them are asked
+	var announceList [][]string
+	for _, trackerURL := range trackerURLs {
+		announceList = append(announceList, []string{trackerURL})
+	}
+	pool := peers.NewPool(infoHashArray, false)
+	trackerPeers, err := peers.NewTiers(announceList).FetchPeers(infoHashArray, nil)
+	pool.Add(peers.SourceTracker, trackerPeers...)
+	if len(trackerPeers) == 0 {
+		// trackerless links, or trackers that know no peers, fall back to the DHT
+		err = startDHT(pool, dhtConfig)
+	}
+	peerList := pool.Peers()
+	if len(peerList) == 0 {
 		fmt.Println("Error fetching peers or no peers available:", err)
-		return nil, nil
+		return nil, nil, nil
 	}
 	fmt.Println(peerList)
 	peerTCPAddr, err := net.ResolveTCPAddr("tcp", peerList[0])
 	if err != nil {
 		fmt.Println("Error resolving TCP address:", err)
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	tcpConn, err := net.DialTCP("tcp", nil, peerTCPAddr)
 	if err != nil {
 		fmt.Println("Error establishing TCP connection:", err)
-		return nil, nil
+		return nil, nil, nil
 	}
 	t := time.Now().Add(9 * time.Second)
 	tcpConn.SetDeadline(t)
-	peerID := tcp.CompleteHandshake(tcpConn, infoHashArray)
-	fmt.Println("Peer ID:", peerID)
-	metadataPieceContents := sendExtensionHandshake(tcpConn, infoHash)
-	return tcpConn, metadataPieceContents
+	peer, err := tcp.CompleteHandshake(tcpConn, infoHashArray, tcp.DefaultReserved)
+	if err != nil {
+		fmt.Println("Error completing handshake:", err)
+		tcpConn.Close()
+		return nil, nil, nil
+	}
+	fmt.Println("Peer ID:", peer.HexID())
+	if !peer.SupportsExtensions() {
+		// metadata can only be fetched over the extension protocol
+		fmt.Println("Peer does not support the extension protocol")
+		tcpConn.Close()
+		return nil, nil, nil
+	}
+	metadataPieceContents, remoteExtensions := sendExtensionHandshake(tcpConn, infoHash)
+	if metadataPieceContents == nil {
+		tcpConn.Close()
+		return nil, nil, nil
+	}
+	// the deadline only bounds fetching the metadata; the connection goes
+	// on to carry the download and peer exchange
+	tcpConn.SetDeadline(time.Time{})
+
+	// only now is it known whether the torrent is private (BEP 27), which
+	// decides which of the peers found so far may be used and whether any
+	// are exchanged
+	swarmPool := peers.NewPool(infoHashArray, metadataPieceContents.IsPrivate())
+	for _, addr := range pool.Peers() {
+		if src, ok := pool.SourceOf(addr); ok {
+			swarmPool.Add(src, addr)
+		}
+	}
+	if exchange, err := pex.Start(tcpConn, swarmPool); err == nil {
+		// a second extension handshake adds ut_pex to the first one
+		if err := sendExtensions(wire.NewWriter(tcpConn), true); err != nil {
+			fmt.Println("Error sending extension handshake:", err)
+			exchange.Close()
+		} else {
+			exchange.SetRemoteID(remoteExtensions[pex.ExtensionName])
+		}
+	}
+	return tcpConn, swarmPool, metadataPieceContents
 }
 
-func sendExtensionHandshake(tcpConn *net.TCPConn, infoHash string) *torrent.InfoData {
+// sendExtensions sends our extension handshake: ut_metadata, and ut_pex
+// once the torrent is known to allow it.
+func sendExtensions(writer *wire.Writer, withPEX bool) error {
+	extensions := map[string]uint8{"ut_metadata": metadataLocalID}
+	if withPEX {
+		extensions[pex.ExtensionName] = pex.LocalID
+	}
+	extensionPayload, err := bencode.Marshal(map[string]interface{}{"m": extensions})
+	if err != nil {
+		return err
+	}
+	return writer.WriteMessage(wire.Extended{ExtendedID: 0, Payload: extensionPayload})
+}
+
+// startDHT looks the torrent up in the DHT. Nothing accepts connections for
+// a magnet download, so config leaves PeerPort unset and the torrent is not
+// announced; the node is only needed for the lookup.
+func startDHT(pool *peers.Pool, config dht.Config) error {
+	config.PeerPort = 0
+	node, err := dht.Start(config)
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+	return pool.Start(node)
+}
+
+// sendExtensionHandshake fetches the torrent's metadata over ut_metadata
+// and returns it with the extensions the peer advertised.
+func sendExtensionHandshake(tcpConn *net.TCPConn, infoHash string) (*torrent.InfoData, map[string]int) {
 	var peerMetaDataExtensionID int
+	var remoteExtensions map[string]int
 	requestMsgSent := false
 
+	reader := wire.NewReader(tcpConn)
+	writer := wire.NewWriter(tcpConn)
 	for {
-		messageLength := make([]byte, 4)
-		_, err := io.ReadFull(tcpConn, messageLength)
-		if err != nil {
-			fmt.Println("Error reading message length:", err)
-			return nil
-		}
-		length := binary.BigEndian.Uint32(messageLength)
-		if length == 0 {
-			fmt.Println("Keep alive message received")
-			return nil
-		}
-
-		messageID := make([]byte, 1)
-		_, err = io.ReadFull(tcpConn, messageID)
+		message, err := reader.ReadMessage()
 		if err != nil {
-			fmt.Println("Error reading message ID:", err)
-			return nil
+			fmt.Println("Error reading message:", err)
+			return nil, nil
 		}
 
-		id := uint8(messageID[0])
-		switch id {
-		case 5:
+		switch message := message.(type) {
+		case wire.KeepAlive:
+			fmt.Println("Keep alive message received")
+			return nil, nil
+		case wire.Bitfield:
 			fmt.Println("Received bitfield message")
-			payload := make([]byte, length-1)
-			_, err := io.ReadFull(tcpConn, payload)
-			if err != nil {
-				fmt.Println("Error reading bitfield payload:", err)
-				return nil
-			}
-
-			bencodedDict := map[string]interface{}{
-				"m": map[string]uint8{"ut_metadata": 20},
-			}
-			var bencodedDictBytesBuffer bytes.Buffer
-			err = bencode.Marshal(&bencodedDictBytesBuffer, bencodedDict)
-			if err != nil {
-				fmt.Println("Error encoding bencoded dictionary:", err)
-				return nil
-			}
 
-			extensionPayload := bencodedDictBytesBuffer.Bytes()
-			messageLen := len(extensionPayload) + 2
-			extensionHandshake := make([]byte, 4+messageLen)
-			binary.BigEndian.PutUint32(extensionHandshake[0:4], uint32(messageLen))
-			extensionHandshake[4] = 20
-			extensionHandshake[5] = 0
-			copy(extensionHandshake[6:], extensionPayload)
 			fmt.Println("Sending extension handshake...")
-			_, err = tcpConn.Write(extensionHandshake)
-			if err != nil {
+			if err := sendExtensions(writer, false); err != nil {
 				fmt.Println("Error sending extension handshake:", err)
 			}
 
-		case 20:
-			payload := make([]byte, length-1)
-			_, err = io.ReadFull(tcpConn, payload)
-			if err != nil {
-				fmt.Println("Error reading extension message payload:", err)
-				return nil
-			}
-
-			extensionMsgID := payload[0]
-			dict := payload[1:]
-			buf := bytes.NewReader(dict)
+		case wire.Extended:
+			extensionMsgID := message.ExtendedID
+			dict := message.Payload
 
 			if extensionMsgID == 0 {
 				extensionMsg := extensionMsg{}
-				err := bencode.Unmarshal(buf, &extensionMsg)
+				err := bencode.Unmarshal(dict, &extensionMsg)
 				if err != nil {
 					fmt.Println("Error unmarshaling extension message:", err)
-					return nil
+					return nil, nil
 				}
 
-				if metadataExtID, ok := extensionMsg.M["ut_metadata"].(int); ok {
+				remoteExtensions = extensionMsg.M
+				if metadataExtID, ok := extensionMsg.M["ut_metadata"]; ok {
 					peerMetaDataExtensionID = metadataExtID
 					fmt.Println("Peer Metadata Extension ID:", peerMetaDataExtensionID)
 
@@ -172,71 +236,57 @@ func sendExtensionHandshake(tcpConn *net.TCPConn, infoHash string) *torrent.Info
 						Msg_type: 0,
 						Piece:    0,
 					}
-					var requestMsgPayloadBuf bytes.Buffer
-					err = bencode.Marshal(&requestMsgPayloadBuf, requestMsgPayload)
+					requestMsgPayloadBytes, err := bencode.Marshal(requestMsgPayload)
 					if err != nil {
 						fmt.Println("Error marshaling request payload:", err)
-						return nil
+						return nil, nil
 					}
 
-					payloadLength := len(requestMsgPayloadBuf.Bytes()) + 2
-					requestMsg := make([]byte, 4+payloadLength)
-					binary.BigEndian.PutUint32(requestMsg[:4], uint32(payloadLength))
-					requestMsg[4] = 20
-					requestMsg[5] = uint8(peerMetaDataExtensionID)
-					copy(requestMsg[6:], requestMsgPayloadBuf.Bytes())
+					requestMsg := wire.Extended{ExtendedID: uint8(peerMetaDataExtensionID), Payload: requestMsgPayloadBytes}
 					fmt.Println("Sending request message...")
-					_, err = tcpConn.Write(requestMsg)
+					err = writer.WriteMessage(requestMsg)
 					if err != nil {
 						fmt.Println("Error sending request message:", err)
-						return nil
+						return nil, nil
 					}
 					requestMsgSent = true
 				} else {
 					fmt.Println("Could not extract metadata extension ID")
-					return nil
+					return nil, nil
 				}
-			} else if requestMsgSent {
-				data, err := bencode.Decode(buf)
-				if err != nil {
+			} else if extensionMsgID == metadataLocalID && requestMsgSent {
+				// the bencoded header is followed by the raw metadata piece
+				decoder := bencode.NewDecoder(bytes.NewReader(dict))
+				var header map[string]interface{}
+				if err := decoder.Decode(&header); err != nil {
 					fmt.Println("Error unmarshaling data message:", err)
-					return nil
+					return nil, nil
 				}
+				infoBytes := dict[decoder.InputOffset():]
 
-				if reflect.TypeOf(data).Kind() == reflect.Map {
-					if metadataMap, ok := data.(map[string]interface{}); ok {
-						var metadataMapBuf bytes.Buffer
-						err = bencode.Marshal(&metadataMapBuf, metadataMap)
-						if err != nil {
-							fmt.Println("Error encoding metadata map:", err)
-							return nil
-						}
-
-						mapBytesLength := metadataMapBuf.Len()
-						metadataPieceContentsBuf := bytes.NewReader(dict[mapBytesLength:])
-
-						var metadataPieceContents torrent.InfoData
-						err = bencode.Unmarshal(metadataPieceContentsBuf, &metadataPieceContents)
-						if err != nil {
-							fmt.Println("Error unmarshaling metadata piece contents:", err)
-							return nil
-						}
-
-						hash, err := infoCommand.GenerateInfoHash(metadataPieceContents)
-						if err != nil {
-							fmt.Println(err)
-							return nil
-						}
-						if infoHash == hex.EncodeToString(hash[:]) {
+				// verify the metadata exactly as the peer sent it before trusting the typed view
+				hash, err := infoCommand.GenerateInfoHash(infoBytes)
+				if err != nil {
+					fmt.Println(err)
+					return nil, nil
+				}
+				if !strings.EqualFold(infoHash, hex.EncodeToString(hash[:])) {
+					fmt.Println("Metadata does not match info hash", infoHash)
+					return nil, nil
+				}
 
-							fmt.Println("Length:", metadataPieceContents.Length)
-							fmt.Println("Info Hash:", hex.EncodeToString(hash[:]))
-							fmt.Println("Piece Length:", metadataPieceContents.Piece_length)
-							fmt.Println("Piece Hashes:", hex.EncodeToString([]byte(metadataPieceContents.Pieces)))
-							return &metadataPieceContents
-						}
-					}
+				var metadataPieceContents torrent.InfoData
+				err = bencode.Unmarshal(infoBytes, &metadataPieceContents)
+				if err != nil {
+					fmt.Println("Error unmarshaling metadata piece contents:", err)
+					return nil, nil
 				}
+
+				fmt.Println("Length:", metadataPieceContents.TotalLength())
+				fmt.Println("Info Hash:", hex.EncodeToString(hash[:]))
+				fmt.Println("Piece Length:", metadataPieceContents.Piece_length)
+				fmt.Println("Piece Hashes:", hex.EncodeToString([]byte(metadataPieceContents.Pieces)))
+				return &metadataPieceContents, remoteExtensions
 			}
 		}
 	}
@@ -244,20 +294,12 @@ func sendExtensionHandshake(tcpConn *net.TCPConn, infoHash string) *torrent.Info
 func DownloadPiece(metadataPieceContents *torrent.InfoData, pieceIndex string, downloadPath string, tcpConn *net.TCPConn) []byte {
 	pieceData := make([]byte, 0)
 	pieceInd, _ := strconv.Atoi(pieceIndex)
-	pieceLength := metadataPieceContents.Piece_length
-
-	if pieceInd == len(metadataPieceContents.Pieces)/20-1 {
-		lastPieceLength := metadataPieceContents.Length % metadataPieceContents.Piece_length
-		if lastPieceLength > 0 {
-			pieceLength = lastPieceLength
-		}
-	}
-	totalBlocks := (pieceLength)/(16*1024) + 1
+	pieceLength := metadataPieceContents.PieceSize(pieceInd)
+	totalBlocks := download.BlockCount(pieceLength)
 	fmt.Println("total blocks", totalBlocks)
 
 	pieceReceivedIndex := 0
-	interested := []byte{0, 0, 0, 1, 2}
-	_, err := tcpConn.Write(interested)
+	err := wire.NewWriter(tcpConn).WriteMessage(wire.Interested{})
 	if err != nil {
 		fmt.Println("Error sending interested message:", err)
 		return nil
@@ -265,32 +307,67 @@ func DownloadPiece(metadataPieceContents *torrent.InfoData, pieceIndex string, d
 	return download.HandleDownloadPiece(tcpConn, pieceInd, totalBlocks, pieceLength, pieceReceivedIndex, pieceData, downloadPath, metadataPieceContents)
 
 }
-func DownloadFile(metadataPieceContents *torrent.InfoData, downloadPath string, tcpConn *net.TCPConn) {
-	totalPieces := len(metadataPieceContents.Pieces) / 20
-	file := make([]byte, 0)
+
+// DownloadFile downloads every piece, taking turns over the peers in pool:
+// the metadata peer over tcpConn, the others, such as those learned through
+// PEX, over connections of their own.
+func DownloadFile(metadataPieceContents *torrent.InfoData, downloadPath string, tcpConn *net.TCPConn, pool *peers.Pool) {
+	defer tcpConn.Close()
+	if exchange := pex.ForConn(tcpConn); exchange != nil {
+		defer exchange.Close()
+	}
+	totalPieces := metadataPieceContents.PieceCount()
 	fmt.Println("total pieces", totalPieces)
+	layout, err := storage.NewLayout(metadataPieceContents, downloadPath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := layout.Create(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	completed := make(map[int]bool)
 	download.AddPiecesToQueue(totalPieces)
 	for !queue.Empty() {
 		pieceIndex := queue.Front()
 		queue.Pop()
+		if completed[pieceIndex] {
+			continue
+		}
 		fmt.Println("piece index", pieceIndex)
-		pieceData := DownloadPiece(metadataPieceContents, strconv.Itoa(pieceIndex), downloadPath, tcpConn)
-		interested := []byte{0, 0, 0, 1, 2}
-		_, err := tcpConn.Write(interested)
+		pieceData, err := downloadPieceFromSwarm(metadataPieceContents, pieceIndex, tcpConn, pool)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
-		file = append(file, pieceData...)
-
+		if pieceData == nil {
+			continue
+		}
+		if err := layout.WritePiece(pieceIndex, pieceData); err != nil {
+			fmt.Println("error saving to ", downloadPath, err)
+			return
+		}
+		completed[pieceIndex] = true
 	}
-	defer tcpConn.Close()
-	err := download.SavePieceToFile(file, downloadPath)
-	if err != nil {
-		defer tcpConn.Close()
+	fmt.Println("File Saved successfully")
+}
 
-		fmt.Println("error saving to ", downloadPath)
-		return
+// downloadPieceFromSwarm asks the pool's peer whose turn it is for the
+// piece, and the metadata peer when that is its own turn or the other peer
+// fails. An error means the metadata peer's connection is lost.
+func downloadPieceFromSwarm(metadataPieceContents *torrent.InfoData, pieceIndex int, tcpConn *net.TCPConn, pool *peers.Pool) ([]byte, error) {
+	if peerList := pool.Peers(); len(peerList) > 1 {
+		peerAddr := peerList[pieceIndex%len(peerList)]
+		if peerAddr != tcpConn.RemoteAddr().String() {
+			if pieceData := download.DownloadPieceFromPeer(peerAddr, pool, metadataPieceContents, pieceIndex, ""); pieceData != nil {
+				return pieceData, nil
+			}
+		}
 	}
-	fmt.Println("File Saved successfully")
+	pieceData := DownloadPiece(metadataPieceContents, strconv.Itoa(pieceIndex), "", tcpConn)
+	if err := wire.NewWriter(tcpConn).WriteMessage(wire.Interested{}); err != nil {
+		return nil, err
+	}
+	return pieceData, nil
 }
@@ -0,0 +1,298 @@
+// Package pex implements the ut_pex peer exchange extension (BEP 11): peers
+// on a connection tell each other about the other peers they know.
+package pex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/wire"
+)
+
+const (
+	// ExtensionName is the key of ut_pex in the extension handshake.
+	ExtensionName = "ut_pex"
+	// LocalID is the extended message id peers use to send us ut_pex.
+	LocalID = 1
+	// maxPeers bounds the added and the dropped peers of one message.
+	maxPeers = 50
+)
+
+// Interval is the least time between two messages to a peer; BEP 11 asks
+// for no more than one a minute.
+var Interval = time.Minute
+
+// Flags describe an added peer.
+const (
+	FlagEncryption = 0x01
+	FlagSeed       = 0x02
+	FlagUTP        = 0x04
+	FlagHolepunch  = 0x08
+	FlagReachable  = 0x10
+)
+
+// Message is a ut_pex message. Peers are compact: 6 bytes each for IPv4 and
+// 18 for IPv6, with one flags byte per added peer.
+type Message struct {
+	Added    string `bencode:"added"`
+	AddedF   string `bencode:"added.f"`
+	Added6   string `bencode:"added6,omitempty"`
+	Added6F  string `bencode:"added6.f,omitempty"`
+	Dropped  string `bencode:"dropped"`
+	Dropped6 string `bencode:"dropped6,omitempty"`
+}
+
+// Peer is an added peer and its flags.
+type Peer struct {
+	Addr  string
+	Flags byte
+}
+
+func compactPeer(addr string) ([]byte, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, false
+	}
+	ip := net.ParseIP(host)
+	portNum, err := strconv.Atoi(port)
+	if ip == nil || err != nil {
+		return nil, false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return binary.BigEndian.AppendUint16(append([]byte(nil), ip...), uint16(portNum)), true
+}
+
+func parseCompactPeers(data string, size int) []string {
+	var addrs []string
+	for i := 0; i+size <= len(data); i += size {
+		ip := net.IP(data[i : i+size-2])
+		port := binary.BigEndian.Uint16([]byte(data[i+size-2 : i+size]))
+		addrs = append(addrs, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return addrs
+}
+
+// NewMessage packs added and dropped peers, at most maxPeers of each.
+func NewMessage(added []Peer, dropped []string) *Message {
+	var m Message
+	for _, peer := range added[:min(len(added), maxPeers)] {
+		compact, ok := compactPeer(peer.Addr)
+		if !ok {
+			continue
+		}
+		if len(compact) == 6 {
+			m.Added += string(compact)
+			m.AddedF += string(peer.Flags)
+		} else {
+			m.Added6 += string(compact)
+			m.Added6F += string(peer.Flags)
+		}
+	}
+	for _, addr := range dropped[:min(len(dropped), maxPeers)] {
+		compact, ok := compactPeer(addr)
+		if !ok {
+			continue
+		}
+		if len(compact) == 6 {
+			m.Dropped += string(compact)
+		} else {
+			m.Dropped6 += string(compact)
+		}
+	}
+	return &m
+}
+
+// AddedPeers returns the added peers of both families with their flags;
+// peers without a flags byte get 0.
+func (m *Message) AddedPeers() []Peer {
+	var added []Peer
+	for _, family := range []struct {
+		peers, flags string
+		size         int
+	}{{m.Added, m.AddedF, 6}, {m.Added6, m.Added6F, 18}} {
+		for i, addr := range parseCompactPeers(family.peers, family.size) {
+			peer := Peer{Addr: addr}
+			if i < len(family.flags) {
+				peer.Flags = family.flags[i]
+			}
+			added = append(added, peer)
+		}
+	}
+	return added
+}
+
+func (m *Message) DroppedPeers() []string {
+	return append(parseCompactPeers(m.Dropped, 6), parseCompactPeers(m.Dropped6, 18)...)
+}
+
+// Exchange runs ut_pex on one peer connection, sending the pool's peers and
+// feeding the peers it hears about back into the pool.
+type Exchange struct {
+	conn net.Conn
+	pool *peers.Pool
+
+	mu       sync.Mutex
+	remoteID int
+	// sent are the addresses the peer has been told about and not dropped.
+	sent     map[string]bool
+	lastSent time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// exchanges finds the exchange of a connection for the code that reads its
+// messages.
+var exchanges = struct {
+	sync.Mutex
+	byConn map[net.Conn]*Exchange
+}{byConn: make(map[net.Conn]*Exchange)}
+
+// Start begins peer exchange on conn for the pool's torrent. The pool
+// refuses it for private torrents.
+func Start(conn net.Conn, pool *peers.Pool) (*Exchange, error) {
+	e := &Exchange{conn: conn, sent: make(map[string]bool), stop: make(chan struct{})}
+	if err := pool.Start(e); err != nil {
+		return nil, err
+	}
+	exchanges.Lock()
+	exchanges.byConn[conn] = e
+	exchanges.Unlock()
+	go e.run()
+	return e, nil
+}
+
+// ForConn returns the exchange running on conn, or nil.
+func ForConn(conn net.Conn) *Exchange {
+	exchanges.Lock()
+	defer exchanges.Unlock()
+	return exchanges.byConn[conn]
+}
+
+func (e *Exchange) Source() peers.Source {
+	return peers.SourcePEX
+}
+
+func (e *Exchange) Discover(infoHash [20]byte, pool *peers.Pool) error {
+	e.pool = pool
+	return nil
+}
+
+// SetRemoteID records the peer's id for ut_pex from its extension
+// handshake and sends it the peers known so far. 0 means the peer doesn't
+// support it.
+func (e *Exchange) SetRemoteID(id int) {
+	e.mu.Lock()
+	e.remoteID = id
+	e.mu.Unlock()
+	if id != 0 {
+		e.send()
+	}
+}
+
+// Handle takes the payload of a ut_pex message from the peer. Added peers go
+// into the pool; dropped ones are forgotten if PEX is where they came from.
+func (e *Exchange) Handle(payload []byte) error {
+	var m Message
+	if err := bencode.Unmarshal(payload, &m); err != nil {
+		return fmt.Errorf("error unmarshalling ut_pex message: %v", err)
+	}
+	added := m.AddedPeers()
+	for _, peer := range added[:min(len(added), maxPeers)] {
+		e.pool.Add(peers.SourcePEX, peer.Addr)
+	}
+	dropped := m.DroppedPeers()
+	for _, addr := range dropped[:min(len(dropped), maxPeers)] {
+		if src, ok := e.pool.SourceOf(addr); ok && src == peers.SourcePEX {
+			e.pool.Remove(addr)
+		}
+	}
+	return nil
+}
+
+// Close stops sending and detaches the exchange from its connection.
+func (e *Exchange) Close() {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+		exchanges.Lock()
+		delete(exchanges.byConn, e.conn)
+		exchanges.Unlock()
+	})
+}
+
+func (e *Exchange) run() {
+	// checked more often than Interval so a message is never a tick late
+	ticker := time.NewTicker(Interval / 6)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		}
+		if err := e.send(); err != nil {
+			e.Close()
+			return
+		}
+	}
+}
+
+// send tells the peer what changed in the pool since the last message. It
+// does nothing before the peer's id is known, when nothing changed, or
+// within Interval of the last message.
+func (e *Exchange) send() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.remoteID == 0 || time.Since(e.lastSent) < Interval {
+		return nil
+	}
+
+	// the peer is never told about itself
+	self := e.conn.RemoteAddr().String()
+	current := make(map[string]bool)
+	var added []Peer
+	for _, addr := range e.pool.Peers() {
+		if addr == self {
+			continue
+		}
+		current[addr] = true
+		// only addresses are known here, so no flags are claimed
+		if !e.sent[addr] && len(added) < maxPeers {
+			added = append(added, Peer{Addr: addr})
+		}
+	}
+	var dropped []string
+	for addr := range e.sent {
+		if !current[addr] && len(dropped) < maxPeers {
+			dropped = append(dropped, addr)
+		}
+	}
+	if len(added) == 0 && len(dropped) == 0 {
+		return nil
+	}
+
+	payload, err := bencode.Marshal(NewMessage(added, dropped))
+	if err != nil {
+		return err
+	}
+	// WriteMessage never interleaves with the download's messages
+	if err := wire.NewWriter(e.conn).WriteMessage(wire.Extended{ExtendedID: uint8(e.remoteID), Payload: payload}); err != nil {
+		return err
+	}
+	for _, peer := range added {
+		e.sent[peer.Addr] = true
+	}
+	for _, addr := range dropped {
+		delete(e.sent, addr)
+	}
+	e.lastSent = time.Now()
+	return nil
+}
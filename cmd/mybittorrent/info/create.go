@@ -0,0 +1,276 @@
+package infoCommand
+
+import (
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+const (
+	minPieceLength = 16 * 1024
+	maxPieceLength = 16 * 1024 * 1024
+	// targetPieces is roughly how many pieces the automatic piece length aims for
+	targetPieces = 1500
+)
+
+type CreateOptions struct {
+	// PieceLength is used as-is when non-zero, otherwise it is picked from the
+	// total size of the content.
+	PieceLength  int
+	AnnounceList [][]string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	Private      bool
+	WebSeeds     []string
+	// Workers is the number of goroutines hashing pieces, runtime.NumCPU() when zero.
+	Workers int
+}
+
+// AutoPieceLength picks a power-of-two piece length between 16 KiB and
+// 16 MiB so that the torrent ends up with about targetPieces pieces.
+func AutoPieceLength(totalLength int) int {
+	pieceLength := minPieceLength
+	for pieceLength < maxPieceLength && totalLength/pieceLength > targetPieces {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// CreateTorrent hashes the file or directory tree at path into a new torrent.
+// Files of a directory are added in lexical path order.
+func CreateTorrent(path string, opts CreateOptions) (*torrent.Torrent, error) {
+	path = filepath.Clean(path)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	info := torrent.InfoData{Name: filepath.Base(path)}
+	if stat.IsDir() {
+		err := filepath.WalkDir(path, func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			fileInfo, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(path, filePath)
+			if err != nil {
+				return err
+			}
+			info.Files = append(info.Files, torrent.FileInfo{
+				Length: int(fileInfo.Size()),
+				Path:   strings.Split(filepath.ToSlash(rel), "/"),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error walking %s: %v", path, err)
+		}
+		if len(info.Files) == 0 {
+			return nil, fmt.Errorf("directory %s contains no files", path)
+		}
+	} else {
+		info.Length = int(stat.Size())
+	}
+	if opts.Private {
+		info.Private = 1
+	}
+
+	totalLength := info.TotalLength()
+	if totalLength == 0 {
+		return nil, fmt.Errorf("cannot create a torrent for empty content at %s", path)
+	}
+	info.Piece_length = opts.PieceLength
+	if info.Piece_length == 0 {
+		info.Piece_length = AutoPieceLength(totalLength)
+	}
+	if info.Piece_length < minPieceLength || info.Piece_length&(info.Piece_length-1) != 0 {
+		return nil, fmt.Errorf("piece length %d must be a power of two of at least %d", info.Piece_length, minPieceLength)
+	}
+
+	pieces, err := hashPieces(&info, filepath.Dir(path), path, opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+	info.Pieces = string(pieces)
+
+	metadata := &torrent.Torrent{
+		Comment:   opts.Comment,
+		CreatedBy: opts.CreatedBy,
+		Info:      info,
+		URLList:   opts.WebSeeds,
+	}
+	for _, tier := range opts.AnnounceList {
+		if len(tier) > 0 {
+			metadata.AnnounceList = append(metadata.AnnounceList, tier)
+		}
+	}
+	if len(metadata.AnnounceList) > 0 {
+		metadata.Announce = metadata.AnnounceList[0][0]
+	}
+	// a single tracker is expressed with announce alone
+	if len(metadata.AnnounceList) == 1 && len(metadata.AnnounceList[0]) == 1 {
+		metadata.AnnounceList = nil
+	}
+	if !opts.CreationDate.IsZero() {
+		metadata.CreationDate = opts.CreationDate.Unix()
+	}
+
+	infoBytes, err := bencode.Marshal(metadata.Info)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding info dictionary: %v", err)
+	}
+	metadata.InfoBytes = infoBytes
+	return metadata, nil
+}
+
+// hashPieces computes the SHA-1 of every piece, spreading the pieces over
+// workers goroutines. Each worker reads its pieces through the storage layout
+// so pieces spanning file boundaries are assembled correctly.
+func hashPieces(info *torrent.InfoData, parent string, path string, workers int) ([]byte, error) {
+	// with no pieces string yet the count is derived from the total length
+	pieceCount := info.PieceCount()
+	pieces := make([]byte, pieceCount*20)
+
+	root := path
+	if info.IsMultiFile() {
+		root = parent
+	}
+	layout, err := storage.NewLayout(info, root)
+	if err != nil {
+		return nil, err
+	}
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	indexes := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pieceIndex := range indexes {
+				pieceData, err := layout.ReadPiece(pieceIndex)
+				if err != nil {
+					errs <- err
+					// keep draining so the producer never blocks
+					for range indexes {
+					}
+					return
+				}
+				hash := sha1.Sum(pieceData)
+				copy(pieces[pieceIndex*20:], hash[:])
+			}
+		}()
+	}
+	for pieceIndex := 0; pieceIndex < pieceCount; pieceIndex++ {
+		indexes <- pieceIndex
+	}
+	close(indexes)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	return pieces, nil
+}
+
+// WriteTorrentFile bencodes metadata into filePath.
+func WriteTorrentFile(metadata *torrent.Torrent, filePath string) error {
+	// the info dictionary is written from InfoBytes so the file hashes to
+	// exactly what was computed when the torrent was created
+	data, err := bencode.Marshal(struct {
+		torrent.Torrent
+		Info bencode.RawMessage `bencode:"info"`
+	}{*metadata, metadata.InfoBytes})
+	if err != nil {
+		return fmt.Errorf("error encoding torrent: %v", err)
+	}
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("error writing file %s: %v", filePath, err)
+	}
+	return nil
+}
+
+// stringList collects a flag that may be given several times.
+type stringList []string
+
+func (s *stringList) String() string     { return strings.Join(*s, ",") }
+func (s *stringList) Set(v string) error { *s = append(*s, v); return nil }
+
+func CreateCommand(args []string) {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	output := flags.String("o", "", "output .torrent file (default <name>.torrent)")
+	pieceLength := flags.Int("piece-length", 0, "piece length in bytes, a power of two (default: automatic)")
+	comment := flags.String("comment", "", "free-form comment")
+	createdBy := flags.String("created-by", "GoTorrent", "created by field")
+	noDate := flags.Bool("no-date", false, "omit the creation date")
+	private := flags.Bool("private", false, "set the private flag (BEP 27)")
+	workers := flags.Int("workers", 0, "number of hashing goroutines (default: number of CPUs)")
+	var trackers, webSeeds stringList
+	flags.Var(&trackers, "t", "tracker tier as a comma-separated list of URLs; repeat for more tiers")
+	flags.Var(&webSeeds, "w", "web seed URL; may be repeated")
+	flags.Usage = func() {
+		fmt.Println("Usage: create [options] <file or directory>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return
+	}
+
+	opts := CreateOptions{
+		PieceLength: *pieceLength,
+		Comment:     *comment,
+		CreatedBy:   *createdBy,
+		Private:     *private,
+		WebSeeds:    webSeeds,
+		Workers:     *workers,
+	}
+	if !*noDate {
+		opts.CreationDate = time.Now()
+	}
+	for _, tier := range trackers {
+		opts.AnnounceList = append(opts.AnnounceList, strings.Split(tier, ","))
+	}
+
+	metadata, err := CreateTorrent(flags.Arg(0), opts)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	outPath := *output
+	if outPath == "" {
+		outPath = metadata.Info.Name + ".torrent"
+	}
+	if err := WriteTorrentFile(metadata, outPath); err != nil {
+		fmt.Println(err)
+		return
+	}
+	infoHash, _ := GenerateInfoHash(metadata.InfoBytes)
+	fmt.Println("Created:", outPath)
+	fmt.Printf("Info Hash: %x\n", infoHash)
+	fmt.Println("Pieces:", metadata.Info.PieceCount(), "of", metadata.Info.Piece_length, "bytes")
+}
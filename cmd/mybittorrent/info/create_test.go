@@ -0,0 +1,193 @@
+// The create tests check the result with the verify package, which itself
+// imports this one.
+package infoCommand_test
+
+import (
+	"crypto/sha1"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	infoCommand "github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/info"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/verify"
+)
+
+func writeRandomFile(t *testing.T, path string, length int) {
+	t.Helper()
+	data := make([]byte, length)
+	rand.Read(data)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// roundTrip writes metadata to a file and loads it back.
+func roundTrip(t *testing.T, metadata *torrent.Torrent) *torrent.Torrent {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out.torrent")
+	if err := infoCommand.WriteTorrentFile(metadata, path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := infoCommand.LoadTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := infoCommand.GenerateInfoHash(loaded.InfoBytes)
+	if hash != sha1.Sum(metadata.InfoBytes) {
+		t.Fatal("info hash changed between create and load")
+	}
+	return loaded
+}
+
+func checkAllValid(t *testing.T, info *torrent.InfoData, contentPath string) {
+	t.Helper()
+	layout, err := storage.NewLayoutAt(info, contentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, status := range verify.CheckPieces(info, layout) {
+		if status != verify.PieceValid {
+			t.Errorf("piece %d of the created torrent is %s", i, status)
+		}
+	}
+}
+
+func TestCreateSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	writeRandomFile(t, path, 6*16384+100)
+	created := time.Unix(1700000000, 0)
+	metadata, err := infoCommand.CreateTorrent(path, infoCommand.CreateOptions{
+		PieceLength:  16384,
+		AnnounceList: [][]string{{"http://a/announce"}, {"http://b/announce", "udp://c:6969"}},
+		Comment:      "test",
+		CreatedBy:    "tests",
+		CreationDate: created,
+		Private:      true,
+		WebSeeds:     []string{"http://seed/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := roundTrip(t, metadata)
+
+	info := &loaded.Info
+	if info.Name != "data.bin" || info.Length != 6*16384+100 || info.IsMultiFile() || !info.IsPrivate() {
+		t.Fatalf("info %+v", info)
+	}
+	if info.PieceCount() != 7 || info.PieceSize(6) != 100 {
+		t.Fatalf("%d pieces with the last of %d bytes, want 7 and 100", info.PieceCount(), info.PieceSize(6))
+	}
+	if loaded.Announce != "http://a/announce" || len(loaded.AnnounceList) != 2 {
+		t.Errorf("trackers %q %v", loaded.Announce, loaded.AnnounceList)
+	}
+	if loaded.Comment != "test" || loaded.CreatedBy != "tests" || loaded.CreationDate != created.Unix() {
+		t.Errorf("comment %q, created by %q, date %d", loaded.Comment, loaded.CreatedBy, loaded.CreationDate)
+	}
+	if !slices.Equal(loaded.URLList, []string{"http://seed/"}) {
+		t.Errorf("web seeds %v", loaded.URLList)
+	}
+	checkAllValid(t, info, path)
+}
+
+func TestCreateMultiFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "content")
+	// the small files make pieces cross file boundaries
+	writeRandomFile(t, filepath.Join(dir, "b.txt"), 20000)
+	writeRandomFile(t, filepath.Join(dir, "a", "y"), 30000)
+	writeRandomFile(t, filepath.Join(dir, "a", "x"), 5)
+	writeRandomFile(t, filepath.Join(dir, "c"), 0)
+	metadata, err := infoCommand.CreateTorrent(dir, infoCommand.CreateOptions{
+		AnnounceList: [][]string{{"http://a/announce"}},
+		Workers:      3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := roundTrip(t, metadata)
+
+	// a single tracker goes in announce alone
+	if loaded.Announce != "http://a/announce" || loaded.AnnounceList != nil {
+		t.Errorf("trackers %q %v", loaded.Announce, loaded.AnnounceList)
+	}
+	info := &loaded.Info
+	wantFiles := []torrent.FileInfo{
+		{Length: 5, Path: []string{"a", "x"}},
+		{Length: 30000, Path: []string{"a", "y"}},
+		{Length: 20000, Path: []string{"b.txt"}},
+		{Length: 0, Path: []string{"c"}},
+	}
+	if info.Name != "content" || !reflect.DeepEqual(info.Files, wantFiles) {
+		t.Fatalf("name %q, files %+v, want the files in path order", info.Name, info.Files)
+	}
+	if info.Piece_length != 16384 || info.PieceCount() != 4 {
+		t.Fatalf("%d pieces of %d bytes", info.PieceCount(), info.Piece_length)
+	}
+	checkAllValid(t, info, dir)
+
+	// hashing doesn't depend on how many workers do it
+	single, err := infoCommand.CreateTorrent(dir, infoCommand.CreateOptions{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.Info.Pieces != metadata.Info.Pieces {
+		t.Fatal("pieces differ with one worker")
+	}
+}
+
+func TestCreateRejects(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	writeRandomFile(t, empty, 0)
+	data := filepath.Join(dir, "data")
+	writeRandomFile(t, data, 100)
+	os.Mkdir(filepath.Join(dir, "nothing"), 0755)
+
+	tests := map[string]struct {
+		path string
+		opts infoCommand.CreateOptions
+	}{
+		"empty file":             {empty, infoCommand.CreateOptions{}},
+		"empty directory":        {filepath.Join(dir, "nothing"), infoCommand.CreateOptions{}},
+		"missing path":           {filepath.Join(dir, "missing"), infoCommand.CreateOptions{}},
+		"small piece length":     {data, infoCommand.CreateOptions{PieceLength: 8192}},
+		"non-power-of-two piece": {data, infoCommand.CreateOptions{PieceLength: 3 * 16384}},
+	}
+	for name, tt := range tests {
+		if _, err := infoCommand.CreateTorrent(tt.path, tt.opts); err == nil {
+			t.Errorf("%s: torrent created", name)
+		}
+	}
+}
+
+func TestAutoPieceLength(t *testing.T) {
+	const kib, mib = 1024, 1024 * 1024
+	tests := []struct {
+		totalLength, want int
+	}{
+		{0, 16 * kib},
+		{1, 16 * kib},
+		// up to 1500 pieces of the minimum size
+		{1500 * 16 * kib, 16 * kib},
+		{1501 * 16 * kib, 32 * kib},
+		{1500 * 32 * kib, 32 * kib},
+		{1500*32*kib + 32*kib, 64 * kib},
+		{1500 * 16 * mib, 16 * mib},
+		// beyond that the pieces stop growing
+		{1 << 40, 16 * mib},
+	}
+	for _, tt := range tests {
+		got := infoCommand.AutoPieceLength(tt.totalLength)
+		if got != tt.want {
+			t.Errorf("AutoPieceLength(%d) = %d, want %d", tt.totalLength, got, tt.want)
+		}
+	}
+}
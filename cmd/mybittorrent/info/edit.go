@@ -0,0 +1,293 @@
+package infoCommand
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+)
+
+// ErrInfoChange is returned when an edit would alter the info dictionary,
+// and with it the info hash, without EditOptions.Force.
+var ErrInfoChange = errors.New("edit changes the info dictionary and therefore the info hash; use -force to allow it")
+
+// EditOptions describes changes to a torrent file. Nil fields are left alone.
+type EditOptions struct {
+	Announce     *string
+	AnnounceList [][]string
+	// ReplaceTrackers drops the existing announce-list before AnnounceList is added.
+	ReplaceTrackers bool
+	WebSeeds        []string
+	ReplaceWebSeeds bool
+	Comment         *string
+	CreatedBy       *string
+
+	// Private and Source live in the info dictionary; setting either changes
+	// the info hash and requires Force.
+	Private *bool
+	Source  *string
+	Force   bool
+}
+
+// EditTorrent applies opts to the bencoded torrent in torrentData. The outer
+// dictionary is rebuilt, keys it doesn't know about are kept, and the info
+// dictionary is copied byte for byte unless an info edit is forced.
+func EditTorrent(torrentData []byte, opts EditOptions) ([]byte, error) {
+	infoBytes, err := RawInfo(torrentData)
+	if err != nil {
+		return nil, fmt.Errorf("error locating info dictionary: %v", err)
+	}
+	var outer map[string]interface{}
+	if err := bencode.Unmarshal(torrentData, &outer); err != nil {
+		return nil, fmt.Errorf("error decoding torrent: %v", err)
+	}
+
+	if opts.Private != nil || opts.Source != nil {
+		if !opts.Force {
+			return nil, ErrInfoChange
+		}
+		infoBytes, err = editInfo(infoBytes, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Announce != nil || opts.ReplaceTrackers || len(opts.AnnounceList) > 0 {
+		editTrackers(outer, opts)
+	}
+	// untouched web seeds keep their form, which may be a single string
+	if opts.ReplaceWebSeeds || len(opts.WebSeeds) > 0 {
+		seeds := webSeedList(outer)
+		if opts.ReplaceWebSeeds {
+			seeds = nil
+		}
+		seeds = append(seeds, opts.WebSeeds...)
+		if len(seeds) > 0 {
+			outer["url-list"] = stringsToList(seeds)
+		} else {
+			delete(outer, "url-list")
+		}
+	}
+
+	if opts.Comment != nil {
+		setOrDelete(outer, "comment", *opts.Comment)
+	}
+	if opts.CreatedBy != nil {
+		setOrDelete(outer, "created by", *opts.CreatedBy)
+	}
+	// the info dictionary goes back in verbatim
+	outer["info"] = bencode.RawMessage(infoBytes)
+	edited, err := bencode.Marshal(outer)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding torrent: %v", err)
+	}
+	return edited, nil
+}
+
+// editTrackers applies the tracker options, keeping announce set to a
+// tracker of the list.
+func editTrackers(outer map[string]interface{}, opts EditOptions) {
+	tiers := announceTiers(outer)
+	if opts.ReplaceTrackers {
+		tiers = nil
+	}
+	for _, tier := range opts.AnnounceList {
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	if opts.Announce != nil {
+		setOrDelete(outer, "announce", *opts.Announce)
+	} else if opts.ReplaceTrackers {
+		delete(outer, "announce")
+	}
+	if _, ok := outer["announce"]; !ok && len(tiers) > 0 {
+		outer["announce"] = tiers[0][0]
+	}
+	if len(tiers) > 0 {
+		list := make([]interface{}, len(tiers))
+		for i, tier := range tiers {
+			list[i] = stringsToList(tier)
+		}
+		outer["announce-list"] = list
+	} else {
+		delete(outer, "announce-list")
+	}
+}
+
+func editInfo(infoBytes []byte, opts EditOptions) ([]byte, error) {
+	var info map[string]interface{}
+	if err := bencode.Unmarshal(infoBytes, &info); err != nil {
+		return nil, fmt.Errorf("error decoding info dictionary: %v", err)
+	}
+	if opts.Private != nil {
+		if *opts.Private {
+			info["private"] = int64(1)
+		} else {
+			delete(info, "private")
+		}
+	}
+	if opts.Source != nil {
+		setOrDelete(info, "source", *opts.Source)
+	}
+	edited, err := bencode.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding info dictionary: %v", err)
+	}
+	return edited, nil
+}
+
+func announceTiers(outer map[string]interface{}) [][]string {
+	var tiers [][]string
+	list, _ := outer["announce-list"].([]interface{})
+	for _, entry := range list {
+		tierList, _ := entry.([]interface{})
+		var tier []string
+		for _, trackerURL := range tierList {
+			if s, ok := trackerURL.(string); ok {
+				tier = append(tier, s)
+			}
+		}
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	return tiers
+}
+
+func webSeedList(outer map[string]interface{}) []string {
+	switch urlList := outer["url-list"].(type) {
+	case string:
+		if urlList != "" {
+			return []string{urlList}
+		}
+	case []interface{}:
+		var seeds []string
+		for _, seed := range urlList {
+			if s, ok := seed.(string); ok {
+				seeds = append(seeds, s)
+			}
+		}
+		return seeds
+	}
+	return nil
+}
+
+func stringsToList(values []string) []interface{} {
+	list := make([]interface{}, len(values))
+	for i, v := range values {
+		list[i] = v
+	}
+	return list
+}
+
+func setOrDelete(dict map[string]interface{}, key string, value string) {
+	if value == "" {
+		delete(dict, key)
+		return
+	}
+	dict[key] = value
+}
+
+func EditCommand(args []string) {
+	flags := flag.NewFlagSet("edit", flag.ContinueOnError)
+	output := flags.String("o", "", "write the result here instead of editing in place")
+	announce := flags.String("announce", "", "set the primary tracker URL (empty removes it)")
+	replaceTrackers := flags.Bool("replace-trackers", false, "drop existing trackers before adding -t tiers")
+	replaceWebSeeds := flags.Bool("replace-webseeds", false, "drop existing web seeds before adding -w seeds")
+	comment := flags.String("comment", "", "set the comment (empty removes it)")
+	createdBy := flags.String("created-by", "", "set the created by field (empty removes it)")
+	private := flags.Bool("private", false, "set or clear the private flag (changes the info hash)")
+	source := flags.String("source", "", "set the info source field (changes the info hash)")
+	force := flags.Bool("force", false, "allow edits that change the info hash")
+	var trackers, webSeeds stringList
+	flags.Var(&trackers, "t", "add a tracker tier as a comma-separated list of URLs; may be repeated")
+	flags.Var(&webSeeds, "w", "add a web seed URL; may be repeated")
+	flags.Usage = func() {
+		fmt.Println("Usage: edit [options] <torrent file>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return
+	}
+
+	opts := EditOptions{
+		ReplaceTrackers: *replaceTrackers,
+		ReplaceWebSeeds: *replaceWebSeeds,
+		WebSeeds:        webSeeds,
+		Force:           *force,
+	}
+	for _, tier := range trackers {
+		opts.AnnounceList = append(opts.AnnounceList, strings.Split(tier, ","))
+	}
+	// only flags given on the command line are applied, so "" can clear a field
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "announce":
+			opts.Announce = announce
+		case "comment":
+			opts.Comment = comment
+		case "created-by":
+			opts.CreatedBy = createdBy
+		case "private":
+			opts.Private = private
+		case "source":
+			opts.Source = source
+		}
+	})
+
+	torrentPath := flags.Arg(0)
+	torrentData, err := os.ReadFile(torrentPath)
+	if err != nil {
+		fmt.Printf("error reading file %s: %v\n", torrentPath, err)
+		return
+	}
+	edited, err := EditTorrent(torrentData, opts)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	outPath := *output
+	if outPath == "" {
+		outPath = torrentPath
+	}
+	// write next to the destination and rename, so a failed write never
+	// leaves a truncated torrent behind
+	tmp, err := os.CreateTemp(filepath.Dir(outPath), ".edit-*.torrent")
+	if err != nil {
+		fmt.Println("error creating temporary file:", err)
+		return
+	}
+	_, err = tmp.Write(edited)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), outPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		fmt.Printf("error writing file %s: %v\n", outPath, err)
+		return
+	}
+
+	before, _ := RawInfo(torrentData)
+	after, _ := RawInfo(edited)
+	oldHash, _ := GenerateInfoHash(before)
+	newHash, _ := GenerateInfoHash(after)
+	fmt.Println("Wrote:", outPath)
+	if oldHash == newHash {
+		fmt.Printf("Info Hash: %x (unchanged)\n", newHash)
+	} else {
+		fmt.Printf("Info Hash: %x (was %x)\n", newHash, oldHash)
+	}
+}
@@ -0,0 +1,139 @@
+package infoCommand
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+)
+
+// editSource is a canonical torrent with keys this client doesn't model,
+// inside and outside the info dictionary, and url-list as a single string.
+const editSource = "d8:announce17:http://a/announce13:announce-listll17:http://a/announceel17:http://b/announceee" +
+	"7:comment3:old4:infod6:lengthi3e4:name1:x12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaa6:x-infoi1ee" +
+	"8:url-list14:http://seed/x/8:x-customd1:ki1eee"
+
+func strPtr(s string) *string { return &s }
+func boolPtr(b bool) *bool    { return &b }
+
+func decodeOuter(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+	var outer map[string]interface{}
+	if err := bencode.Unmarshal(data, &outer); err != nil {
+		t.Fatal(err)
+	}
+	return outer
+}
+
+func TestEditTorrentKeepsInfoHash(t *testing.T) {
+	sourceInfo, _ := RawInfo([]byte(editSource))
+	tests := []struct {
+		name string
+		opts EditOptions
+		// want are the outer keys that change; every other key must be kept
+		want map[string]interface{}
+	}{
+		{"nothing", EditOptions{}, nil},
+		{"comment", EditOptions{Comment: strPtr("new")}, map[string]interface{}{"comment": "new"}},
+		{"remove comment", EditOptions{Comment: strPtr("")}, map[string]interface{}{"comment": nil}},
+		{"created by", EditOptions{CreatedBy: strPtr("me")}, map[string]interface{}{"created by": "me"}},
+		{"add tier", EditOptions{AnnounceList: [][]string{{"http://c/announce"}}}, map[string]interface{}{
+			"announce-list": []interface{}{
+				[]interface{}{"http://a/announce"}, []interface{}{"http://b/announce"}, []interface{}{"http://c/announce"},
+			},
+		}},
+		{"replace trackers", EditOptions{ReplaceTrackers: true, AnnounceList: [][]string{{"http://c/announce", "http://d/announce"}}}, map[string]interface{}{
+			"announce":      "http://c/announce",
+			"announce-list": []interface{}{[]interface{}{"http://c/announce", "http://d/announce"}},
+		}},
+		{"drop trackers", EditOptions{ReplaceTrackers: true}, map[string]interface{}{"announce": nil, "announce-list": nil}},
+		{"add web seed", EditOptions{WebSeeds: []string{"http://mirror/"}}, map[string]interface{}{
+			"url-list": []interface{}{"http://seed/x/", "http://mirror/"},
+		}},
+		{"replace web seeds", EditOptions{ReplaceWebSeeds: true}, map[string]interface{}{"url-list": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edited, err := EditTorrent([]byte(editSource), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := RawInfo(edited)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(info, sourceInfo) {
+				t.Fatalf("info dictionary changed to %s", info)
+			}
+			want := decodeOuter(t, []byte(editSource))
+			for key, value := range tt.want {
+				if value == nil {
+					delete(want, key)
+				} else {
+					want[key] = value
+				}
+			}
+			if got := decodeOuter(t, edited); !reflect.DeepEqual(got, want) {
+				t.Fatalf("torrent edited to\n%v\nwant\n%v", got, want)
+			}
+		})
+	}
+
+	// with no edits the file comes back byte for byte
+	edited, err := EditTorrent([]byte(editSource), EditOptions{Comment: strPtr("old")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(edited) != editSource {
+		t.Fatalf("no-op edit rewrote the torrent as\n%s", edited)
+	}
+}
+
+func TestEditTorrentInfoChanges(t *testing.T) {
+	for name, opts := range map[string]EditOptions{
+		"private": {Private: boolPtr(true)},
+		"public":  {Private: boolPtr(false)},
+		"source":  {Source: strPtr("tracker.example")},
+		"both":    {Private: boolPtr(true), Source: strPtr("tracker.example"), Comment: strPtr("new")},
+	} {
+		if _, err := EditTorrent([]byte(editSource), opts); !errors.Is(err, ErrInfoChange) {
+			t.Errorf("%s without force = %v, want ErrInfoChange", name, err)
+		}
+	}
+
+	edited, err := EditTorrent([]byte(editSource), EditOptions{Private: boolPtr(true), Source: strPtr("tracker.example"), Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := RawInfo(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var infoDict map[string]interface{}
+	if err := bencode.Unmarshal(info, &infoDict); err != nil {
+		t.Fatal(err)
+	}
+	if infoDict["private"] != int64(1) || infoDict["source"] != "tracker.example" {
+		t.Fatalf("forced edit gave info %v", infoDict)
+	}
+	if infoDict["x-info"] != int64(1) {
+		t.Fatal("unknown info key dropped")
+	}
+	if decodeOuter(t, edited)["url-list"] != "http://seed/x/" {
+		t.Fatal("outer keys changed by an info edit")
+	}
+}
+
+func TestEditTorrentAnnounceFromList(t *testing.T) {
+	// a torrent with only an announce-list gets announce set from it
+	source := "d13:announce-listll17:http://a/announceee4:infod6:lengthi3e4:name1:x12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaaee"
+	edited, err := EditTorrent([]byte(source), EditOptions{AnnounceList: [][]string{{"http://b/announce"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeOuter(t, edited)["announce"]; got != "http://a/announce" {
+		t.Fatalf("announce = %v, want the first tracker of the list", got)
+	}
+}
@@ -1,16 +1,19 @@
 package infoCommand
 
 import (
-	"bytes"
 	"crypto/sha1"
-	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"time"
 
-	 "github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
-	"github.com/jackpal/bencode-go"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
 )
+
 func LoadTorrentFile(filePath string) (*torrent.Torrent, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -23,38 +26,127 @@ func LoadTorrentFile(filePath string) (*torrent.Torrent, error) {
 		return nil, fmt.Errorf("error reading file %s: %v", filePath, err)
 	}
 
-	buf := bytes.NewReader(torrentData)
 	metadata := torrent.Torrent{}
-	if err := bencode.Unmarshal(buf, &metadata); err != nil {
+	if err := bencode.Unmarshal(torrentData, &metadata); err != nil {
 		return nil, fmt.Errorf("error unmarshalling torrent data: %v", err)
 	}
+	metadata.InfoBytes, err = RawInfo(torrentData)
+	if err != nil {
+		return nil, fmt.Errorf("error locating info dictionary in %s: %v", filePath, err)
+	}
+	if metadata.Info.IsV2() {
+		if err := loadFileTree(&metadata); err != nil {
+			return nil, fmt.Errorf("error loading v2 metadata from %s: %v", filePath, err)
+		}
+	}
 	return &metadata, nil
 }
-func GenerateInfoHash(info torrent.InfoData) ([20]byte, error) {
-	var infoBuff bytes.Buffer
-	err := bencode.Marshal(&infoBuff, info)
-	if err != nil {
-		return [20]byte{}, fmt.Errorf("error encoding info dictionary: %v", err)
+
+// RawInfo returns the info dictionary of a bencoded torrent exactly as it
+// appears in torrentData.
+func RawInfo(torrentData []byte) ([]byte, error) {
+	var outer struct {
+		Info bencode.RawMessage `bencode:"info"`
+	}
+	if err := bencode.Unmarshal(torrentData, &outer); err != nil {
+		return nil, err
+	}
+	if len(outer.Info) == 0 || outer.Info[0] != 'd' {
+		return nil, fmt.Errorf("no info dictionary")
 	}
-	hash := sha1.Sum(infoBuff.Bytes())
-	return hash, nil
+	return outer.Info, nil
 }
 
-func InfoCommand(bencodedValue string) {
-	metadata, err := LoadTorrentFile(bencodedValue)
+// GenerateInfoHash hashes the raw bencoded info dictionary. Re-encoding the
+// typed InfoData would drop every key the struct doesn't model.
+func GenerateInfoHash(infoBytes []byte) ([20]byte, error) {
+	if len(infoBytes) == 0 {
+		return [20]byte{}, fmt.Errorf("empty info dictionary")
+	}
+	return sha1.Sum(infoBytes), nil
+}
+
+func InfoCommand(args []string) {
+	flags := flag.NewFlagSet("info", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print the description as JSON")
+	flags.Usage = func() {
+		fmt.Println("Usage: info [--json] <torrent file>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return
+	}
+	metadata, err := LoadTorrentFile(flags.Arg(0))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	infoHash, err := GenerateInfoHash(metadata.Info)
+	description, err := Describe(metadata)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	fmt.Println("Tracker URL:", metadata.Announce)
-	fmt.Println("Length:", metadata.Info.Length)
-	fmt.Println("Info Hash:", hex.EncodeToString(infoHash[:]))
-	fmt.Println("Piece Length:", metadata.Info.Piece_length)
-	fmt.Println("Piece Hashes:", hex.EncodeToString([]byte(metadata.Info.Pieces)))
+	if *jsonOutput {
+		out, err := json.MarshalIndent(description, "", "  ")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	fmt.Println("Tracker URL:", description.Announce)
+	fmt.Println("Length:", description.Length)
+	if description.InfoHash != "" {
+		fmt.Println("Info Hash:", description.InfoHash)
+	}
+	if description.InfoHashV2 != "" {
+		fmt.Println("Info Hash v2:", description.InfoHashV2)
+	}
+	fmt.Println("Piece Length:", description.PieceLength)
+	fmt.Println("Name:", description.Name)
+	fmt.Println("Total Pieces:", description.PieceCount)
+	if description.Hybrid {
+		fmt.Println("Meta Version:", description.MetaVersion, "(hybrid)")
+	} else {
+		fmt.Println("Meta Version:", description.MetaVersion)
+	}
+	fmt.Println("Private:", description.Private)
+	if description.CreationDate != 0 {
+		fmt.Println("Creation Date:", time.Unix(description.CreationDate, 0).UTC().Format(time.RFC3339))
+	}
+	if description.CreatedBy != "" {
+		fmt.Println("Created By:", description.CreatedBy)
+	}
+	if description.Comment != "" {
+		fmt.Println("Comment:", description.Comment)
+	}
+	fmt.Println("Trackers:")
+	for i, tier := range description.TrackerTiers {
+		fmt.Printf("  Tier %d: %s\n", i+1, strings.Join(tier, ", "))
+	}
+	if len(description.WebSeeds) > 0 {
+		fmt.Println("Web Seeds:")
+		for _, seed := range description.WebSeeds {
+			fmt.Println("  " + seed)
+		}
+	}
+	fmt.Println("Files:")
+	for _, file := range description.Files {
+		pieces := "no pieces"
+		if file.FirstPiece >= 0 {
+			pieces = fmt.Sprintf("pieces %d-%d", file.FirstPiece, file.LastPiece)
+		}
+		fmt.Printf("  %s (%d bytes, %s)\n", file.Path, file.Length, pieces)
+	}
+	fmt.Println("Piece Hashes:")
+	for _, hash := range description.PieceHashes {
+		fmt.Println(hash)
+	}
 }
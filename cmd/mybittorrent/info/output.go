@@ -0,0 +1,121 @@
+package infoCommand
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+// Description is everything the info command reports about a torrent. Its
+// JSON form is the schema of `info --json`; fields are only ever added to it.
+type Description struct {
+	Name         string     `json:"name"`
+	InfoHash     string     `json:"info_hash,omitempty"`
+	InfoHashV2   string     `json:"info_hash_v2,omitempty"`
+	MetaVersion  int        `json:"meta_version"`
+	Hybrid       bool       `json:"hybrid"`
+	Private      bool       `json:"private"`
+	Announce     string     `json:"announce,omitempty"`
+	TrackerTiers [][]string `json:"tracker_tiers"`
+	WebSeeds     []string   `json:"web_seeds"`
+	Comment      string     `json:"comment,omitempty"`
+	CreatedBy    string     `json:"created_by,omitempty"`
+	// CreationDate is seconds since the Unix epoch, 0 when absent.
+	CreationDate int64             `json:"creation_date"`
+	Length       int               `json:"length"`
+	PieceLength  int               `json:"piece_length"`
+	PieceCount   int               `json:"piece_count"`
+	Files        []FileDescription `json:"files"`
+	// PieceHashes are the hex v1 SHA-1 piece hashes, empty for v2-only torrents.
+	PieceHashes []string `json:"piece_hashes"`
+}
+
+type FileDescription struct {
+	Path   string `json:"path"`
+	Length int    `json:"length"`
+	// FirstPiece and LastPiece are -1 for empty files, which cover no piece.
+	FirstPiece int `json:"first_piece"`
+	LastPiece  int `json:"last_piece"`
+	// PiecesRoot is the hex v2 merkle root, which identifies identical files
+	// across torrents.
+	PiecesRoot string `json:"pieces_root,omitempty"`
+}
+
+func Describe(metadata *torrent.Torrent) (*Description, error) {
+	info := &metadata.Info
+	description := &Description{
+		Name:         info.Name,
+		MetaVersion:  1,
+		Hybrid:       info.IsHybrid(),
+		Private:      info.IsPrivate(),
+		Announce:     metadata.Announce,
+		TrackerTiers: metadata.Trackers(),
+		WebSeeds:     metadata.URLList,
+		Comment:      metadata.Comment,
+		CreatedBy:    metadata.CreatedBy,
+		CreationDate: metadata.CreationDate,
+		PieceLength:  info.Piece_length,
+		PieceCount:   info.PieceCount(),
+		PieceHashes:  []string{},
+	}
+	if description.TrackerTiers == nil {
+		description.TrackerTiers = [][]string{}
+	}
+	if description.WebSeeds == nil {
+		description.WebSeeds = []string{}
+	}
+	if info.IsV1() {
+		infoHash, err := GenerateInfoHash(metadata.InfoBytes)
+		if err != nil {
+			return nil, err
+		}
+		description.InfoHash = hex.EncodeToString(infoHash[:])
+		for pieceIndex := 0; pieceIndex < info.PieceCount(); pieceIndex++ {
+			description.PieceHashes = append(description.PieceHashes, hex.EncodeToString(info.V1PieceHash(pieceIndex)))
+		}
+	}
+	if info.IsV2() {
+		description.MetaVersion = 2
+		infoHashV2, err := GenerateInfoHashV2(metadata.InfoBytes)
+		if err != nil {
+			return nil, err
+		}
+		description.InfoHashV2 = hex.EncodeToString(infoHashV2[:])
+	}
+
+	roots := make(map[string]string)
+	for _, file := range info.FileTree {
+		roots[strings.Join(file.Path, "/")] = hex.EncodeToString([]byte(file.PiecesRoot))
+	}
+	layout, err := storage.NewLayoutAt(info, "")
+	if err != nil {
+		return nil, err
+	}
+	description.Files = []FileDescription{}
+	for _, file := range layout.Files {
+		if file.Pad {
+			continue
+		}
+		fileDescription := FileDescription{
+			Path:       strings.Join(file.TorrentPath, "/"),
+			Length:     file.Length,
+			FirstPiece: -1,
+			LastPiece:  -1,
+		}
+		if file.Length > 0 {
+			fileDescription.FirstPiece = file.Offset / info.Piece_length
+			fileDescription.LastPiece = (file.Offset + file.Length - 1) / info.Piece_length
+		}
+		// file tree paths are relative to the torrent name except for single-file torrents
+		relative := strings.Join(file.TorrentPath[1:], "/")
+		if !info.IsMultiFile() {
+			relative = info.Name
+		}
+		fileDescription.PiecesRoot = roots[relative]
+		description.Length += file.Length
+		description.Files = append(description.Files, fileDescription)
+	}
+	return description, nil
+}
@@ -0,0 +1,102 @@
+package infoCommand
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// captureStdout returns what f prints, as the commands print their results.
+func captureStdout(t *testing.T, f func()) []byte {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- data
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+// v1Torrent is a private multi-file torrent with every optional key the
+// description reports, and an empty file.
+func v1Torrent() map[string]interface{} {
+	content := make([]byte, 20000+15000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	var pieces []byte
+	for start := 0; start < len(content); start += 16384 {
+		hash := sha1.Sum(content[start:min(start+16384, len(content))])
+		pieces = append(pieces, hash[:]...)
+	}
+	return map[string]interface{}{
+		"announce": "http://a/announce",
+		"announce-list": []interface{}{
+			[]interface{}{"http://a/announce"},
+			[]interface{}{"http://b/announce", "udp://c:6969"},
+		},
+		"comment":       "golden",
+		"created by":    "tests",
+		"creation date": 1700000000,
+		"info": map[string]interface{}{
+			"files": []interface{}{
+				map[string]interface{}{"length": 20000, "path": []interface{}{"one"}},
+				map[string]interface{}{"length": 0, "path": []interface{}{"empty"}},
+				map[string]interface{}{"length": 15000, "path": []interface{}{"two", "three"}},
+			},
+			"name":         "album",
+			"piece length": 16384,
+			"pieces":       string(pieces),
+			"private":      1,
+		},
+		"url-list": []interface{}{"http://seed/"},
+	}
+}
+
+func TestInfoJSONGolden(t *testing.T) {
+	c := fixedV2Content()
+	tests := map[string]map[string]interface{}{
+		"v1": v1Torrent(),
+		"hybrid": {
+			"announce":     "http://tracker/announce",
+			"info":         c.hybridInfo(),
+			"piece layers": c.pieceLayers(),
+		},
+	}
+	for name, torrentDict := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeTorrent(t, torrentDict)
+			got := captureStdout(t, func() { InfoCommand([]string{"--json", path}) })
+
+			golden := filepath.Join("testdata", "info_"+name+".json")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("info --json printed\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package infoCommand
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+// GenerateInfoHashV2 is the BEP 52 SHA-256 info hash of the raw info dictionary.
+func GenerateInfoHashV2(infoBytes []byte) ([32]byte, error) {
+	if len(infoBytes) == 0 {
+		return [32]byte{}, fmt.Errorf("empty info dictionary")
+	}
+	return sha256.Sum256(infoBytes), nil
+}
+
+// SwarmHashes returns the 20-byte hashes the torrent is known by on trackers
+// and in handshakes: the v1 SHA-1 hash, the v2 hash truncated to 20 bytes,
+// or both (v1 first) for hybrid torrents.
+func SwarmHashes(metadata *torrent.Torrent) ([][20]byte, error) {
+	var hashes [][20]byte
+	if metadata.Info.IsV1() {
+		hash, err := GenerateInfoHash(metadata.InfoBytes)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if metadata.Info.IsV2() {
+		hash, err := GenerateInfoHashV2(metadata.InfoBytes)
+		if err != nil {
+			return nil, err
+		}
+		var truncated [20]byte
+		copy(truncated[:], hash[:20])
+		hashes = append(hashes, truncated)
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("torrent has neither v1 pieces nor a v2 file tree")
+	}
+	return hashes, nil
+}
+
+// loadFileTree fills in the v2 view of a torrent: the flattened file tree
+// and its piece layers. A v2-only torrent also gets v1-style Length or Files
+// (with padding entries) so the piece-to-file layout works unchanged.
+func loadFileTree(metadata *torrent.Torrent) error {
+	info := &metadata.Info
+	var infoDict map[string]interface{}
+	if err := bencode.Unmarshal(metadata.InfoBytes, &infoDict); err != nil {
+		return fmt.Errorf("error decoding info dictionary: %v", err)
+	}
+	fileTree, ok := infoDict["file tree"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("v2 torrent has no file tree")
+	}
+	if info.Piece_length < 16*1024 || info.Piece_length&(info.Piece_length-1) != 0 {
+		return fmt.Errorf("v2 piece length %d is not a power of two of at least 16 KiB", info.Piece_length)
+	}
+
+	info.FileTree = nil
+	if err := walkFileTree(fileTree, nil, &info.FileTree); err != nil {
+		return err
+	}
+	nextPiece := 0
+	for i := range info.FileTree {
+		file := &info.FileTree[i]
+		file.FirstPiece = nextPiece
+		nextPiece += (file.Length + info.Piece_length - 1) / info.Piece_length
+	}
+	info.PieceLayers = metadata.PieceLayers
+	if !info.ValidPieceLayers() {
+		return fmt.Errorf("piece layers do not match the file tree roots")
+	}
+
+	if info.IsV1() {
+		return nil
+	}
+	if len(info.FileTree) == 1 && len(info.FileTree[0].Path) == 1 && info.FileTree[0].Path[0] == info.Name {
+		info.Length = info.FileTree[0].Length
+		return nil
+	}
+	info.Files = nil
+	for _, file := range info.FileTree {
+		if file.Length == 0 {
+			continue
+		}
+		if len(info.Files) > 0 {
+			previous := info.Files[len(info.Files)-1].Length
+			if pad := (info.Piece_length - previous%info.Piece_length) % info.Piece_length; pad > 0 {
+				info.Files = append(info.Files, torrent.FileInfo{Length: pad, Path: []string{".pad", strconv.Itoa(pad)}, Attr: "p"})
+			}
+		}
+		info.Files = append(info.Files, torrent.FileInfo{Length: file.Length, Path: file.Path})
+	}
+	return nil
+}
+
+// walkFileTree flattens the nested file tree in key order. A file is a
+// directory entry whose only key is the empty string.
+func walkFileTree(node map[string]interface{}, path []string, files *[]torrent.V2File) error {
+	keys := make([]string, 0, len(node))
+	for key := range node {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		child, ok := node[key].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("malformed file tree entry %q", key)
+		}
+		if key == "" {
+			length, _ := child["length"].(int64)
+			root, _ := child["pieces root"].(string)
+			if length > 0 && len(root) != 32 {
+				return fmt.Errorf("file %v has no valid pieces root", path)
+			}
+			*files = append(*files, torrent.V2File{Path: append([]string(nil), path...), Length: int(length), PiecesRoot: root})
+			continue
+		}
+		if err := walkFileTree(child, append(path, key), files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package infoCommand
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/merkle"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+const v2PieceLength = 16 * 1024
+
+// writeTorrent bencodes torrentDict into a file and returns its path.
+func writeTorrent(t *testing.T, torrentDict map[string]interface{}) string {
+	t.Helper()
+	data, err := bencode.Marshal(torrentDict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "test.torrent")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// v2Content is the content of a v2 test torrent: a, three pieces with the
+// last one short, an empty file and sub/b, shorter than a piece.
+type v2Content struct {
+	a, b []byte
+}
+
+func newV2Content() v2Content {
+	c := v2Content{a: make([]byte, 2*v2PieceLength+7232), b: make([]byte, 100)}
+	rand.Read(c.a)
+	rand.Read(c.b)
+	return c
+}
+
+// fixedV2Content has the same layout as newV2Content with content that is
+// the same on every run, for tests that compare hashes to golden output.
+func fixedV2Content() v2Content {
+	c := v2Content{a: make([]byte, 2*v2PieceLength+7232), b: make([]byte, 100)}
+	for i := range c.a {
+		c.a[i] = byte(i % 251)
+	}
+	for i := range c.b {
+		c.b[i] = byte(i * 13)
+	}
+	return c
+}
+
+func fileEntry(data []byte) map[string]interface{} {
+	entry := map[string]interface{}{"length": len(data)}
+	if len(data) > 0 {
+		root := merkle.FileRoot(data, v2PieceLength)
+		entry["pieces root"] = string(root[:])
+	}
+	return map[string]interface{}{"": entry}
+}
+
+func (c v2Content) fileTree() map[string]interface{} {
+	return map[string]interface{}{
+		"a":     fileEntry(c.a),
+		"empty": fileEntry(nil),
+		"sub":   map[string]interface{}{"b": fileEntry(c.b)},
+	}
+}
+
+// pieceLayers only lists a, the one file longer than a piece.
+func (c v2Content) pieceLayers() map[string]interface{} {
+	var layer []byte
+	for _, hash := range merkle.PieceLayer(c.a, v2PieceLength) {
+		layer = append(layer, hash[:]...)
+	}
+	root := merkle.FileRoot(c.a, v2PieceLength)
+	return map[string]interface{}{string(root[:]): string(layer)}
+}
+
+// pieces returns the content as it is laid out in pieces, every file
+// starting on a piece boundary.
+func (c v2Content) pieces() [][]byte {
+	var pieces [][]byte
+	for start := 0; start < len(c.a); start += v2PieceLength {
+		pieces = append(pieces, c.a[start:min(start+v2PieceLength, len(c.a))])
+	}
+	return append(pieces, c.b)
+}
+
+func TestLoadV2Torrent(t *testing.T) {
+	c := newV2Content()
+	path := writeTorrent(t, map[string]interface{}{
+		"announce": "http://tracker/announce",
+		"info": map[string]interface{}{
+			"file tree":    c.fileTree(),
+			"meta version": 2,
+			"name":         "dir",
+			"piece length": v2PieceLength,
+		},
+		"piece layers": c.pieceLayers(),
+	})
+	metadata, err := LoadTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &metadata.Info
+	if !info.IsV2() || info.IsV1() || info.IsHybrid() {
+		t.Fatal("not loaded as a v2-only torrent")
+	}
+
+	// the tree is walked in key order, and each file starts a new piece
+	wantTree := []torrent.V2File{
+		{Path: []string{"a"}, Length: len(c.a), FirstPiece: 0},
+		{Path: []string{"empty"}, Length: 0, FirstPiece: 3},
+		{Path: []string{"sub", "b"}, Length: len(c.b), FirstPiece: 3},
+	}
+	for i := range info.FileTree {
+		info.FileTree[i].PiecesRoot = ""
+	}
+	if !reflect.DeepEqual(info.FileTree, wantTree) {
+		t.Fatalf("file tree %+v, want %+v", info.FileTree, wantTree)
+	}
+	if len(info.PieceLayers) != 1 {
+		t.Fatalf("%d piece layers, want a's", len(info.PieceLayers))
+	}
+
+	// the client pads a to a piece boundary itself, as a v1 layout would
+	wantFiles := []torrent.FileInfo{
+		{Length: len(c.a), Path: []string{"a"}},
+		{Length: v2PieceLength - 7232, Path: []string{".pad", "9152"}, Attr: "p"},
+		{Length: len(c.b), Path: []string{"sub", "b"}},
+	}
+	if !reflect.DeepEqual(info.Files, wantFiles) {
+		t.Fatalf("files %+v, want %+v", info.Files, wantFiles)
+	}
+	if info.PieceCount() != 4 {
+		t.Fatalf("%d pieces, want 4", info.PieceCount())
+	}
+}
+
+func TestV2LastPieceOfEachFile(t *testing.T) {
+	c := newV2Content()
+	path := writeTorrent(t, map[string]interface{}{
+		"info": map[string]interface{}{
+			"file tree":    c.fileTree(),
+			"meta version": 2,
+			"name":         "dir",
+			"piece length": v2PieceLength,
+		},
+		"piece layers": c.pieceLayers(),
+	})
+	metadata, err := LoadTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &metadata.Info
+	// piece 2 is a's short last piece, filled out by padding; piece 3 is b
+	// and the torrent's last piece
+	wantSizes := []int{v2PieceLength, v2PieceLength, v2PieceLength, len(c.b)}
+	for i, piece := range c.pieces() {
+		if got := info.PieceSize(i); got != wantSizes[i] {
+			t.Errorf("piece %d is %d bytes, want %d", i, got, wantSizes[i])
+		}
+		padded := append(append([]byte(nil), piece...), make([]byte, info.PieceSize(i)-len(piece))...)
+		if !info.VerifyPiece(i, padded) {
+			t.Errorf("piece %d does not verify", i)
+		}
+		padded[len(piece)-1] ^= 1
+		if info.VerifyPiece(i, padded) {
+			t.Errorf("corrupted piece %d verifies", i)
+		}
+	}
+}
+
+// padded is the content in the v1 view of a hybrid torrent, which lists the
+// same files with BEP 47 padding between them.
+func (c v2Content) padded() []byte {
+	return append(append(append([]byte(nil), c.a...), make([]byte, 9152)...), c.b...)
+}
+
+// hybridInfo is the info dictionary of a hybrid torrent of the content,
+// whose v1 pieces hash the padded layout.
+func (c v2Content) hybridInfo() map[string]interface{} {
+	padded := c.padded()
+	var pieces []byte
+	for start := 0; start < len(padded); start += v2PieceLength {
+		hash := sha1.Sum(padded[start:min(start+v2PieceLength, len(padded))])
+		pieces = append(pieces, hash[:]...)
+	}
+	return map[string]interface{}{
+		"file tree": c.fileTree(),
+		"files": []interface{}{
+			map[string]interface{}{"length": len(c.a), "path": []interface{}{"a"}},
+			map[string]interface{}{"attr": "p", "length": 9152, "path": []interface{}{".pad", "9152"}},
+			map[string]interface{}{"length": len(c.b), "path": []interface{}{"sub", "b"}},
+		},
+		"meta version": 2,
+		"name":         "dir",
+		"piece length": v2PieceLength,
+		"pieces":       string(pieces),
+	}
+}
+
+func TestLoadHybridTorrent(t *testing.T) {
+	c := newV2Content()
+	padded := c.padded()
+	path := writeTorrent(t, map[string]interface{}{
+		"info":         c.hybridInfo(),
+		"piece layers": c.pieceLayers(),
+	})
+	metadata, err := LoadTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &metadata.Info
+	if !info.IsHybrid() {
+		t.Fatal("not loaded as a hybrid torrent")
+	}
+	if len(info.FileTree) != 3 || len(info.Files) != 3 || !info.Files[1].IsPad() {
+		t.Fatalf("hybrid files %+v, tree %+v", info.Files, info.FileTree)
+	}
+
+	hashes, err := SwarmHashes(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, v2 := sha1.Sum(metadata.InfoBytes), sha256.Sum256(metadata.InfoBytes)
+	if len(hashes) != 2 || hashes[0] != v1 || hashes[1] != [20]byte(v2[:20]) {
+		t.Fatal("hybrid torrent is not in both the v1 and the v2 swarm")
+	}
+
+	// both hashes are checked: data that only matches v1 is refused
+	for i := 0; i < info.PieceCount(); i++ {
+		piece := padded[i*v2PieceLength : min((i+1)*v2PieceLength, len(padded))]
+		if !info.VerifyPiece(i, piece) {
+			t.Errorf("piece %d does not verify", i)
+		}
+	}
+	for root := range info.PieceLayers {
+		info.PieceLayers[root] = string(make([]byte, 3*32))
+	}
+	if info.VerifyPiece(0, padded[:v2PieceLength]) {
+		t.Error("piece verified against v1 alone")
+	}
+}
+
+func TestLoadV2TorrentRejectsBadMetadata(t *testing.T) {
+	c := newV2Content()
+	info := func() map[string]interface{} {
+		return map[string]interface{}{
+			"file tree":    c.fileTree(),
+			"meta version": 2,
+			"name":         "dir",
+			"piece length": v2PieceLength,
+		}
+	}
+
+	wrongLayers := c.pieceLayers()
+	for root := range wrongLayers {
+		wrongLayers[root] = string(make([]byte, 3*32))
+	}
+	oddPieceLength := info()
+	oddPieceLength["piece length"] = 3 * v2PieceLength
+	noRoot := info()
+	noRoot["file tree"] = map[string]interface{}{"a": map[string]interface{}{"": map[string]interface{}{"length": 10}}}
+
+	tests := map[string]map[string]interface{}{
+		"missing piece layers": {"info": info()},
+		"wrong piece layers":   {"info": info(), "piece layers": wrongLayers},
+		"piece length":         {"info": oddPieceLength, "piece layers": c.pieceLayers()},
+		"file without root":    {"info": noRoot},
+	}
+	for name, torrentDict := range tests {
+		if _, err := LoadTorrentFile(writeTorrent(t, torrentDict)); err == nil {
+			t.Errorf("%s: torrent loaded", name)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Package lsd implements Local Service Discovery (BEP 14): clients on the
+// same network announce the torrents they have by multicast and find each
+// other without a tracker.
+package lsd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
+)
+
+// Interval is how often each torrent is announced. BEP 14 asks for no more
+// than once a minute.
+var Interval = 5 * time.Minute
+
+var (
+	group4 = &net.UDPAddr{IP: net.IPv4(239, 192, 152, 143), Port: 6771}
+	group6 = &net.UDPAddr{IP: net.ParseIP("ff15::efc0:988f"), Port: 6771}
+)
+
+// multicastGroup is one address family's group with the sockets used for
+// it.
+type multicastGroup struct {
+	addr   *net.UDPAddr
+	listen *net.UDPConn
+	send   *net.UDPConn
+}
+
+// Service announces torrents on the local network and adds the peers
+// announcing the same torrents to their pools.
+type Service struct {
+	port   int
+	cookie string
+	groups []*multicastGroup
+
+	mu    sync.Mutex
+	pools map[[20]byte]*peers.Pool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Start joins the BEP 14 multicast groups on ifi, or the system's default
+// interface when ifi is nil, to announce the peer port. It needs at least
+// one of IPv4 and IPv6 to work.
+func Start(port int, ifi *net.Interface) (*Service, error) {
+	cookie := make([]byte, 8)
+	rand.Read(cookie)
+	s := &Service{
+		port:   port,
+		cookie: hex.EncodeToString(cookie),
+		pools:  make(map[[20]byte]*peers.Pool),
+		stop:   make(chan struct{}),
+	}
+	var lastErr error
+	for _, addr := range []*net.UDPAddr{group4, group6} {
+		g, err := joinGroup(addr, ifi)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		s.groups = append(s.groups, g)
+	}
+	if len(s.groups) == 0 {
+		return nil, fmt.Errorf("error joining local service discovery group: %v", lastErr)
+	}
+	for _, g := range s.groups {
+		s.wg.Add(1)
+		go s.receive(g)
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+func joinGroup(addr *net.UDPAddr, ifi *net.Interface) (*multicastGroup, error) {
+	network := "udp4"
+	if addr.IP.To4() == nil {
+		network = "udp6"
+	}
+	listen, err := net.ListenMulticastUDP(network, ifi, addr)
+	if err != nil {
+		return nil, err
+	}
+	// Linux sends multicast out of the interface that owns the source
+	// address, so binding to it picks ifi
+	local := &net.UDPAddr{}
+	if ifi != nil {
+		local.IP = interfaceAddr(ifi, network)
+	}
+	send, err := net.ListenUDP(network, local)
+	if err != nil {
+		listen.Close()
+		return nil, err
+	}
+	return &multicastGroup{addr: addr, listen: listen, send: send}, nil
+}
+
+func interfaceAddr(ifi *net.Interface, network string) net.IP {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if (ipNet.IP.To4() != nil) == (network == "udp4") {
+			return ipNet.IP
+		}
+	}
+	return nil
+}
+
+func (s *Service) Source() peers.Source {
+	return peers.SourceLSD
+}
+
+// Discover announces infoHash on the local network now and every Interval,
+// and adds the peers that announce it to pool.
+func (s *Service) Discover(infoHash [20]byte, pool *peers.Pool) error {
+	s.mu.Lock()
+	s.pools[infoHash] = pool
+	s.mu.Unlock()
+	return s.announce(infoHash)
+}
+
+// Close leaves the multicast groups and stops announcing.
+func (s *Service) Close() {
+	close(s.stop)
+	for _, g := range s.groups {
+		g.listen.Close()
+		g.send.Close()
+	}
+	s.wg.Wait()
+}
+
+func (s *Service) message(host string, infoHash [20]byte) []byte {
+	return []byte(fmt.Sprintf("BT-SEARCH * HTTP/1.1\r\nHost: %s\r\nPort: %d\r\nInfohash: %s\r\ncookie: %s\r\n\r\n\r\n",
+		host, s.port, hex.EncodeToString(infoHash[:]), s.cookie))
+}
+
+func (s *Service) announce(infoHash [20]byte) error {
+	var lastErr error
+	sent := false
+	for _, g := range s.groups {
+		if _, err := g.send.WriteToUDP(s.message(g.addr.String(), infoHash), g.addr); err != nil {
+			lastErr = err
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		return fmt.Errorf("error sending local service discovery announce: %v", lastErr)
+	}
+	return nil
+}
+
+func (s *Service) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		var hashes [][20]byte
+		for infoHash := range s.pools {
+			hashes = append(hashes, infoHash)
+		}
+		s.mu.Unlock()
+		for _, infoHash := range hashes {
+			if err := s.announce(infoHash); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+func (s *Service) receive(g *multicastGroup) {
+	defer s.wg.Done()
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := g.listen.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+			continue
+		}
+		port, hashes, cookie, ok := parseAnnounce(buf[:n])
+		// our own announces come back over the multicast loop
+		if !ok || cookie == s.cookie {
+			continue
+		}
+		addr := peerAddr(from, port)
+		s.mu.Lock()
+		for _, infoHash := range hashes {
+			if pool := s.pools[infoHash]; pool != nil {
+				pool.Add(peers.SourceLSD, addr)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// peerAddr is the address of the peer that sent an announce from from. A
+// link-local IPv6 sender is only reachable through its zone.
+func peerAddr(from *net.UDPAddr, port int) string {
+	host := from.IP.String()
+	if from.Zone != "" {
+		host += "%" + from.Zone
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// parseAnnounce reads a BT-SEARCH message, which is laid out like an HTTP
+// request and may carry several Infohash headers.
+func parseAnnounce(data []byte) (int, [][20]byte, string, bool) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil || req.Method != "BT-SEARCH" {
+		return 0, nil, "", false
+	}
+	port, err := strconv.Atoi(req.Header.Get("Port"))
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, nil, "", false
+	}
+	var hashes [][20]byte
+	for _, value := range req.Header.Values("Infohash") {
+		var infoHash [20]byte
+		value = strings.TrimSpace(value)
+		if len(value) != 40 {
+			continue
+		}
+		if _, err := hex.Decode(infoHash[:], []byte(value)); err != nil {
+			continue
+		}
+		hashes = append(hashes, infoHash)
+	}
+	return port, hashes, req.Header.Get("Cookie"), len(hashes) > 0
+}
@@ -0,0 +1,115 @@
+package lsd
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
+)
+
+// startOnLoopback starts a service announcing port on the loopback
+// interface, which needs multicast enabled.
+func startOnLoopback(t *testing.T, port int) *Service {
+	t.Helper()
+	lo, err := net.InterfaceByName("lo")
+	if err != nil || lo.Flags&net.FlagMulticast == 0 {
+		t.Skip("loopback interface without multicast")
+	}
+	s, err := Start(port, lo)
+	if err != nil {
+		t.Skipf("local service discovery unavailable: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// ports returns the ports of the peers in pool.
+func ports(pool *peers.Pool) map[int]bool {
+	found := make(map[int]bool)
+	for _, addr := range pool.Peers() {
+		_, port, _ := net.SplitHostPort(addr)
+		n, _ := strconv.Atoi(port)
+		found[n] = true
+	}
+	return found
+}
+
+func waitForPeers(pool *peers.Pool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for len(pool.Peers()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServicesFindEachOther(t *testing.T) {
+	a := startOnLoopback(t, 50001)
+	b := startOnLoopback(t, 50002)
+	infoHash := [20]byte{0x14}
+	poolA := peers.NewPool(infoHash, false)
+	poolB := peers.NewPool(infoHash, false)
+	if err := poolA.Start(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := poolB.Start(b); err != nil {
+		t.Fatal(err)
+	}
+	waitForPeers(poolA)
+	waitForPeers(poolB)
+
+	// each service hears its own announces too, and must drop them by cookie
+	if got := ports(poolA); len(got) != 1 || !got[50002] {
+		t.Errorf("first service found peers %v, want only the second", poolA.Peers())
+	}
+	if got := ports(poolB); len(got) != 1 || !got[50001] {
+		t.Errorf("second service found peers %v, want only the first", poolB.Peers())
+	}
+	if src, ok := poolA.SourceOf(poolA.Peers()[0]); !ok || src != peers.SourceLSD {
+		t.Error("peer not recorded as found through LSD")
+	}
+}
+
+func TestPrivatePoolIsRefused(t *testing.T) {
+	a := startOnLoopback(t, 50003)
+	b := startOnLoopback(t, 50004)
+	infoHash := [20]byte{0x27}
+	private := peers.NewPool(infoHash, true)
+	if err := private.Start(a); err != peers.ErrPrivateTorrent {
+		t.Fatalf("Start on a private pool = %v, want ErrPrivateTorrent", err)
+	}
+	// the other service announces the same torrent, but the private pool
+	// was never registered, and it hears no announce of it either
+	public := peers.NewPool(infoHash, false)
+	if err := public.Start(b); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if len(private.Peers()) != 0 {
+		t.Errorf("private pool took LSD peers %v", private.Peers())
+	}
+	if len(public.Peers()) != 0 {
+		t.Errorf("private torrent was announced: the other service found %v", public.Peers())
+	}
+}
+
+func TestPeerAddrKeepsZone(t *testing.T) {
+	tests := []struct {
+		from *net.UDPAddr
+		want string
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("192.168.1.5"), Port: 6771}, "192.168.1.5:6881"},
+		{&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 6771, Zone: "eth0"}, "[fe80::1%eth0]:6881"},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 6771}, "[2001:db8::1]:6881"},
+	}
+	for _, tt := range tests {
+		got := peerAddr(tt.from, 6881)
+		if got != tt.want {
+			t.Errorf("peerAddr(%v) = %q, want %q", tt.from, got, tt.want)
+		}
+		// the address must still dial the sender, zone included
+		if _, err := net.ResolveTCPAddr("tcp", got); err != nil {
+			t.Errorf("%q does not resolve: %v", got, err)
+		}
+	}
+}
@@ -2,15 +2,22 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/decode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/dht"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/download"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/extensions/magnet"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/info"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peerid"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/tcp"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/tracker"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/verify"
 )
 
 // Ensures gofmt doesn't remove the "os" encoding/json import (feel free to remove this!)
@@ -19,8 +26,30 @@ var _ = json.Marshal
 func main() {
 	fmt.Fprintln(os.Stderr, "Logs from your program will appear here!")
 
+	// options that apply to every command come before it
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	// lets every instance in a fleet identify itself, e.g. -GT0001- plus a host tag
+	prefix := flags.String("peer-id-prefix", peerid.DefaultPrefix, "client prefix of the session's peer ID, at most 12 bytes")
+	bootstrap := flags.String("dht-bootstrap", "", "comma-separated host:port DHT nodes to join through instead of the public routers")
+	// someone is waiting on every command, so UDP trackers get the same
+	// budget as HTTP ones rather than the hours BEP 15 retries for
+	udpTimeout := flags.Duration("udp-timeout", 15*time.Second, "time limit of each UDP tracker request; 0 retries on the full BEP 15 schedule")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		return
+	}
+	if err := peerid.SetPrefix(*prefix); err != nil {
+		fmt.Println(err)
+		return
+	}
+	peers.UDPTimeout = *udpTimeout
+	os.Args = append(os.Args[:1], flags.Args()...)
+	dhtConfig := dht.Config{StatePath: dht.DefaultStatePath()}
+	if *bootstrap != "" {
+		dhtConfig.BootstrapNodes = strings.Split(*bootstrap, ",")
+	}
+
 	if len(os.Args) < 3 {
-		fmt.Println("Usage: <command> <bencoded_value>")
+		fmt.Println("Usage: [-peer-id-prefix prefix] [-dht-bootstrap nodes] [-udp-timeout duration] <command> <bencoded_value>")
 		return
 	}
 
@@ -28,29 +57,49 @@ func main() {
 	bencodedValue := os.Args[2]
 	switch command {
 	case "decode":
-		decode.DecodeCommand(bencodedValue)
+		decode.DecodeCommand(os.Args[2:])
+	case "encode":
+		decode.EncodeCommand(os.Args[2:])
 	case "info":
-		infoCommand.InfoCommand(bencodedValue)
+		infoCommand.InfoCommand(os.Args[2:])
+	case "create":
+		infoCommand.CreateCommand(os.Args[2:])
+	case "edit":
+		infoCommand.EditCommand(os.Args[2:])
 	case "peers":
 		peers.PeersCommand(bencodedValue)
+	case "scrape":
+		peers.ScrapeCommand(os.Args[2:])
+	case "tracker":
+		tracker.TrackerCommand(os.Args[2:])
 	case "handshake":
 		tcp.ConnectTCP(bencodedValue, os.Args[3])
 	case "download_piece":
-		download.DownloadPiece(os.Args[4], os.Args[3], os.Args[5])
+		download.DownloadPieceCommand(os.Args[2:])
 	case "download":
-		download.DownloadFile(os.Args[4], os.Args[3])
+		download.DownloadCommand(os.Args[2:])
+	case "verify":
+		verify.VerifyCommand(os.Args[2:])
 	case "magnet_parse":
-		magnet.ParseMagnetLinks(os.Args[2])
+		if _, _, err := magnet.ParseMagnetLinks(os.Args[2]); err != nil {
+			fmt.Println(err)
+		}
 	case "magnet_handshake":
-		magnet.MagnetHandshake(os.Args[2])
+		magnet.MagnetHandshake(os.Args[2], dhtConfig)
 	case "magnet_info":
-		magnet.MagnetHandshake(os.Args[2])
+		magnet.MagnetHandshake(os.Args[2], dhtConfig)
 	case "magnet_download_piece":
-		tcpConn, metadataPieceContents := magnet.MagnetHandshake(os.Args[4])
+		tcpConn, _, metadataPieceContents := magnet.MagnetHandshake(os.Args[4], dhtConfig)
+		if metadataPieceContents == nil {
+			return
+		}
 		magnet.DownloadPiece(metadataPieceContents, os.Args[5], os.Args[3], tcpConn)
 	case "magnet_download":
-		tcpConn, metadataPieceContents := magnet.MagnetHandshake(os.Args[4])
-		magnet.DownloadFile(metadataPieceContents, os.Args[3], tcpConn)
+		tcpConn, pool, metadataPieceContents := magnet.MagnetHandshake(os.Args[4], dhtConfig)
+		if metadataPieceContents == nil {
+			return
+		}
+		magnet.DownloadFile(metadataPieceContents, os.Args[3], tcpConn, pool)
 	default:
 		fmt.Println("Unknown command:", command)
 	}
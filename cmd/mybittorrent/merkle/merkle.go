@@ -0,0 +1,97 @@
+// Package merkle implements the SHA-256 hash trees of BitTorrent v2 (BEP 52).
+// Leaves are the hashes of 16 KiB blocks; missing leaves beyond the end of a
+// file are zero hashes.
+package merkle
+
+import (
+	"crypto/sha256"
+)
+
+const BlockSize = 16 * 1024
+
+// BlockHashes returns the leaf hashes of data, one per 16 KiB block.
+func BlockHashes(data []byte) [][32]byte {
+	var hashes [][32]byte
+	for start := 0; start < len(data); start += BlockSize {
+		end := min(start+BlockSize, len(data))
+		hashes = append(hashes, sha256.Sum256(data[start:end]))
+	}
+	return hashes
+}
+
+// RootWithPad hashes nodes pairwise up to a single root. The layer is padded
+// to width entries (a power of two) with pad, which must be the hash of an
+// all-zero subtree of the same height as nodes.
+func RootWithPad(nodes [][32]byte, width int, pad [32]byte) [32]byte {
+	layer := make([][32]byte, width)
+	copy(layer, nodes)
+	for i := len(nodes); i < width; i++ {
+		layer[i] = pad
+	}
+	for len(layer) > 1 {
+		next := make([][32]byte, len(layer)/2)
+		for i := range next {
+			next[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		layer = next
+		pad = hashPair(pad, pad)
+	}
+	return layer[0]
+}
+
+// Root returns the root over leaf hashes padded with zero leaves to width.
+func Root(leaves [][32]byte, width int) [32]byte {
+	return RootWithPad(leaves, width, [32]byte{})
+}
+
+// ZeroRoot is the root of a subtree of width zero leaves.
+func ZeroRoot(width int) [32]byte {
+	return Root(nil, width)
+}
+
+// NextPowerOfTwo returns the smallest power of two that is at least n.
+func NextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
+
+// PieceHash is the piece-layer hash of one piece: the root over its blocks,
+// padded to the number of blocks in a full piece.
+func PieceHash(pieceData []byte, pieceLength int) [32]byte {
+	return Root(BlockHashes(pieceData), pieceLength/BlockSize)
+}
+
+// FileRoot computes a file's "pieces root". Files no larger than one piece
+// are hashed from their blocks directly, larger ones from their piece layer.
+func FileRoot(data []byte, pieceLength int) [32]byte {
+	if len(data) <= pieceLength {
+		blocks := BlockHashes(data)
+		return Root(blocks, NextPowerOfTwo(len(blocks)))
+	}
+	return LayerRoot(PieceLayer(data, pieceLength), pieceLength)
+}
+
+// PieceLayer returns the hashes of every piece of a file.
+func PieceLayer(data []byte, pieceLength int) [][32]byte {
+	var layer [][32]byte
+	for start := 0; start < len(data); start += pieceLength {
+		end := min(start+pieceLength, len(data))
+		layer = append(layer, PieceHash(data[start:end], pieceLength))
+	}
+	return layer
+}
+
+// LayerRoot returns the root of a file tree given its piece layer.
+func LayerRoot(layer [][32]byte, pieceLength int) [32]byte {
+	return RootWithPad(layer, NextPowerOfTwo(len(layer)), ZeroRoot(pieceLength/BlockSize))
+}
+
+func hashPair(left, right [32]byte) [32]byte {
+	var buf [64]byte
+	copy(buf[:32], left[:])
+	copy(buf[32:], right[:])
+	return sha256.Sum256(buf[:])
+}
@@ -0,0 +1,81 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"math/rand"
+	"testing"
+)
+
+func pair(left, right [32]byte) [32]byte {
+	return sha256.Sum256(append(left[:], right[:]...))
+}
+
+func TestRoot(t *testing.T) {
+	a, b, c := sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b")), sha256.Sum256([]byte("c"))
+	var zero [32]byte
+	if got := Root([][32]byte{a}, 1); got != a {
+		t.Error("root of a single leaf is not the leaf")
+	}
+	if got := Root([][32]byte{a, b}, 2); got != pair(a, b) {
+		t.Error("wrong root for two leaves")
+	}
+	want := pair(pair(a, b), pair(c, zero))
+	if got := Root([][32]byte{a, b, c}, 4); got != want {
+		t.Error("wrong root for three leaves padded to four")
+	}
+	if got := ZeroRoot(4); got != pair(pair(zero, zero), pair(zero, zero)) {
+		t.Error("wrong zero root")
+	}
+}
+
+func TestBlockHashes(t *testing.T) {
+	data := make([]byte, 2*BlockSize+10)
+	rand.Read(data)
+	hashes := BlockHashes(data)
+	if len(hashes) != 3 {
+		t.Fatalf("got %d block hashes, want 3", len(hashes))
+	}
+	if hashes[2] != sha256.Sum256(data[2*BlockSize:]) {
+		t.Error("last block is not hashed as a short block")
+	}
+}
+
+func TestPieceHashPadsShortPieces(t *testing.T) {
+	const pieceLength = 4 * BlockSize
+	data := make([]byte, BlockSize+1)
+	rand.Read(data)
+	blocks := BlockHashes(data)
+	var zero [32]byte
+	want := pair(pair(blocks[0], blocks[1]), pair(zero, zero))
+	if got := PieceHash(data, pieceLength); got != want {
+		t.Error("short piece is not padded with zero leaves")
+	}
+}
+
+func TestNextPowerOfTwo(t *testing.T) {
+	for n, want := range map[int]int{0: 1, 1: 1, 2: 2, 3: 4, 5: 8, 1024: 1024, 1025: 2048} {
+		if got := NextPowerOfTwo(n); got != want {
+			t.Errorf("NextPowerOfTwo(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+// The root over a file's piece layer must equal the root over all of its
+// blocks, since the piece layer is just a level of the same tree.
+func TestFileRootMatchesBlockTree(t *testing.T) {
+	const pieceLength = 2 * BlockSize
+	for _, size := range []int{1, BlockSize, pieceLength, pieceLength + 1, 5*BlockSize + 7, 8 * pieceLength} {
+		data := make([]byte, size)
+		rand.Read(data)
+		blocks := BlockHashes(data)
+		want := Root(blocks, NextPowerOfTwo(len(blocks)))
+		if got := FileRoot(data, pieceLength); got != want {
+			t.Errorf("size %d: FileRoot differs from the root over all blocks", size)
+		}
+		if size > pieceLength {
+			if got := LayerRoot(PieceLayer(data, pieceLength), pieceLength); got != want {
+				t.Errorf("size %d: LayerRoot differs from the root over all blocks", size)
+			}
+		}
+	}
+}
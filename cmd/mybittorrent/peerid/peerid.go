@@ -0,0 +1,62 @@
+// Package peerid holds the peer ID this client uses for the whole session,
+// in trackers' announces and in peer handshakes alike.
+package peerid
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+)
+
+// DefaultPrefix is the Azureus-style client identification: "GT" for
+// GoTorrent and a four digit version.
+const DefaultPrefix = "-GT0001-"
+
+// MaxPrefixLength leaves at least 8 random characters, so instances
+// sharing a prefix still get IDs of their own.
+const MaxPrefixLength = 12
+
+// randomChars are used for the rest of the ID so it stays printable.
+const randomChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+var (
+	mu     sync.Mutex
+	prefix = DefaultPrefix
+	id     *[20]byte
+)
+
+// SetPrefix changes the client prefix and starts a new session ID with it.
+func SetPrefix(p string) error {
+	if len(p) > MaxPrefixLength {
+		return fmt.Errorf("peer ID prefix %q is longer than %d bytes", p, MaxPrefixLength)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	prefix = p
+	id = nil
+	return nil
+}
+
+// ID returns the session's peer ID, generating it on first use.
+func ID() [20]byte {
+	mu.Lock()
+	defer mu.Unlock()
+	if id == nil {
+		generated := Generate(prefix)
+		id = &generated
+	}
+	return *id
+}
+
+// Generate returns a new peer ID made of prefix followed by random
+// characters.
+func Generate(prefix string) [20]byte {
+	var peerID [20]byte
+	n := copy(peerID[:], prefix)
+	random := make([]byte, 20-n)
+	rand.Read(random)
+	for i, b := range random {
+		peerID[n+i] = randomChars[int(b)%len(randomChars)]
+	}
+	return peerID
+}
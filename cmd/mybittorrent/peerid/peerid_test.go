@@ -0,0 +1,52 @@
+package peerid
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetPrefix(t *testing.T) {
+	t.Cleanup(func() { SetPrefix(DefaultPrefix) })
+
+	if err := SetPrefix("-GT0001-h7-"); err != nil {
+		t.Fatal(err)
+	}
+	first := ID()
+	if !strings.HasPrefix(string(first[:]), "-GT0001-h7-") {
+		t.Fatalf("peer ID %q does not start with the prefix", first)
+	}
+	if ID() != first {
+		t.Fatal("peer ID changed within the session")
+	}
+
+	if err := SetPrefix("-GT0001-host7"); err == nil {
+		t.Fatal("13 byte prefix accepted")
+	}
+	if err := SetPrefix(strings.Repeat("x", 20)); err == nil {
+		t.Fatal("prefix filling the whole peer ID accepted")
+	}
+	if ID() != first {
+		t.Fatal("rejected prefix changed the peer ID")
+	}
+}
+
+func TestGenerateRandomSuffix(t *testing.T) {
+	prefix := strings.Repeat("p", MaxPrefixLength)
+	seen := make(map[[20]byte]bool)
+	for i := 0; i < 100; i++ {
+		id := Generate(prefix)
+		if string(id[:MaxPrefixLength]) != prefix {
+			t.Fatalf("peer ID %q does not start with the prefix", id)
+		}
+		for _, c := range id[MaxPrefixLength:] {
+			if !strings.ContainsRune(randomChars, rune(c)) {
+				t.Fatalf("peer ID %q has a non-printable suffix", id)
+			}
+		}
+		seen[id] = true
+	}
+	// instances sharing the longest prefix still get different IDs
+	if len(seen) != 100 {
+		t.Fatalf("%d distinct IDs out of 100", len(seen))
+	}
+}
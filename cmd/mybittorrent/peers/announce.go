@@ -0,0 +1,166 @@
+package peers
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+// Tiers is a BEP 12 announce-list. Trackers within a tier are shuffled once
+// and then tried in order; a tracker that answers is moved to the front of
+// its tier so it is asked first next time.
+type Tiers struct {
+	mu    sync.Mutex
+	tiers [][]string
+	// trackerIDs are the tracker ids handed out by each tracker URL
+	trackerIDs map[string]string
+}
+
+func NewTiers(announceList [][]string) *Tiers {
+	t := &Tiers{trackerIDs: make(map[string]string)}
+	for _, tier := range announceList {
+		shuffled := append([]string(nil), tier...)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		t.tiers = append(t.tiers, shuffled)
+	}
+	return t
+}
+
+// List returns a copy of the tiers in their current order.
+func (t *Tiers) List() [][]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([][]string, len(t.tiers))
+	for i, tier := range t.tiers {
+		list[i] = append([]string(nil), tier...)
+	}
+	return list
+}
+
+func (t *Tiers) promote(tierIndex int, trackerURL string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tier := t.tiers[tierIndex]
+	for i, u := range tier {
+		if u == trackerURL {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = trackerURL
+			return
+		}
+	}
+}
+
+// trackerErrors are the failures of every tracker tried in an announce;
+// errors.As finds a TrackerError among them.
+type trackerErrors []error
+
+func (e trackerErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e trackerErrors) Unwrap() []error {
+	return e
+}
+
+// trackerID records id as the tracker id of trackerURL, if it isn't empty,
+// and returns the one currently known.
+func (t *Tiers) trackerID(trackerURL string, id string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if id != "" {
+		t.trackerIDs[trackerURL] = id
+	}
+	return t.trackerIDs[trackerURL]
+}
+
+// Announce sends req to every tier concurrently, using the first tracker
+// of each tier that answers, and merges the peers without duplicates. The
+// intervals are the shortest any tier asked for, so no tier is announced to
+// later than it wants. It only fails when no tracker in any tier could be
+// reached.
+func (t *Tiers) Announce(req AnnounceRequest) (*AnnounceResponse, error) {
+	type tierResult struct {
+		resp *AnnounceResponse
+		errs []error
+	}
+	tiers := t.List()
+	results := make([]tierResult, len(tiers))
+	var wg sync.WaitGroup
+	for i, tier := range tiers {
+		wg.Add(1)
+		go func(i int, tier []string) {
+			defer wg.Done()
+			for _, trackerURL := range tier {
+				trackerReq := req
+				trackerReq.TrackerID = t.trackerID(trackerURL, "")
+				resp, err := Announce(trackerURL, trackerReq)
+				if err != nil {
+					results[i].errs = append(results[i].errs, err)
+					continue
+				}
+				if resp.Warning != "" {
+					fmt.Printf("Tracker %s warning: %s\n", trackerURL, resp.Warning)
+				}
+				t.trackerID(trackerURL, resp.TrackerID)
+				t.promote(i, trackerURL)
+				results[i].resp = resp
+				return
+			}
+		}(i, tier)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	var merged *AnnounceResponse
+	var errs trackerErrors
+	for _, result := range results {
+		errs = append(errs, result.errs...)
+		if result.resp == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &AnnounceResponse{Interval: result.resp.Interval, MinInterval: result.resp.MinInterval}
+		}
+		// tiers may track the same swarm, so their counts can't be added up
+		merged.Seeders = max(merged.Seeders, result.resp.Seeders)
+		merged.Leechers = max(merged.Leechers, result.resp.Leechers)
+		if result.resp.Interval > 0 && (merged.Interval == 0 || result.resp.Interval < merged.Interval) {
+			merged.Interval = result.resp.Interval
+		}
+		if result.resp.MinInterval > 0 && (merged.MinInterval == 0 || result.resp.MinInterval < merged.MinInterval) {
+			merged.MinInterval = result.resp.MinInterval
+		}
+		for _, peer := range result.resp.Peers {
+			if !seen[peer] {
+				seen[peer] = true
+				merged.Peers = append(merged.Peers, peer)
+			}
+		}
+	}
+	if merged == nil {
+		if len(errs) == 0 {
+			return nil, fmt.Errorf("torrent has no trackers")
+		}
+		return nil, fmt.Errorf("no tracker answered: %w", errs)
+	}
+	return merged, nil
+}
+
+// FetchPeers announces as a client that has nothing of the torrent yet and
+// returns the merged peers of all tiers.
+func (t *Tiers) FetchPeers(infoHash [20]byte, metadata *torrent.Torrent) ([]string, error) {
+	resp, err := t.Announce(NewAnnounceRequest(infoHash, metadata))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Peers, nil
+}
@@ -0,0 +1,177 @@
+package peers
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	infoCommand "github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/info"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+// defaultInterval is used when a tracker doesn't say how often to announce.
+const defaultInterval = 30 * time.Minute
+
+// Announcer keeps the trackers informed for the life of a download: it
+// announces started, reannounces on the tracker's interval with the current
+// transfer counters, and sends completed and stopped. Peers from every
+// announce go into its pool.
+type Announcer struct {
+	tiers *Tiers
+	pool  *Pool
+
+	mu        sync.Mutex
+	req       AnnounceRequest
+	completed bool
+	stopped   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartAnnouncer sends started for the torrent, with left bytes still to
+// download and peers accepted on port, and begins reannouncing. Like FetchSwarmPeers it settles on the
+// first swarm hash that yields peers, leaving any swarm it tried before.
+func StartAnnouncer(metadata *torrent.Torrent, left int64, port int) (*Announcer, error) {
+	hashes, err := infoCommand.SwarmHashes(metadata)
+	if err != nil {
+		return nil, err
+	}
+	var key [4]byte
+	rand.Read(key[:])
+
+	a := &Announcer{tiers: NewTiers(metadata.Trackers())}
+	var resp *AnnounceResponse
+	var lastErr error
+	for i, infoHash := range hashes {
+		a.req = NewAnnounceRequest(infoHash, metadata)
+		a.req.Left = left
+		a.req.Port = port
+		a.req.Key = binary.BigEndian.Uint32(key[:])
+		a.req.Event = EventStarted
+		resp, err = a.tiers.Announce(a.req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(resp.Peers) > 0 || i == len(hashes)-1 {
+			break
+		}
+		a.req.Event = EventStopped
+		a.tiers.Announce(a.req)
+		resp = nil
+	}
+	if resp == nil {
+		return nil, lastErr
+	}
+
+	a.req.Event = EventNone
+	// a download that starts out complete never sends completed
+	a.completed = left == 0
+	a.pool = NewPool(a.req.InfoHash, metadata.Info.IsPrivate())
+	a.pool.Add(SourceTracker, resp.Peers...)
+	a.stop = make(chan struct{})
+	a.done = make(chan struct{})
+	go a.run(nextInterval(resp))
+	return a, nil
+}
+
+// Pool holds every peer the trackers have returned so far.
+func (a *Announcer) Pool() *Pool {
+	return a.pool
+}
+
+// AddDownloaded counts n more bytes downloaded and n fewer left.
+func (a *Announcer) AddDownloaded(n int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.req.Downloaded += n
+	a.req.Left -= n
+	if a.req.Left < 0 {
+		a.req.Left = 0
+	}
+}
+
+func (a *Announcer) AddUploaded(n int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.req.Uploaded += n
+}
+
+// Completed tells the trackers the download has finished. Only the first
+// call announces.
+func (a *Announcer) Completed() error {
+	a.mu.Lock()
+	if a.completed || a.stopped {
+		a.mu.Unlock()
+		return nil
+	}
+	a.completed = true
+	a.req.Left = 0
+	a.mu.Unlock()
+	_, err := a.announce(EventCompleted)
+	return err
+}
+
+// Stop ends reannouncing and tells the trackers the client is leaving.
+func (a *Announcer) Stop() error {
+	a.mu.Lock()
+	if a.stopped {
+		a.mu.Unlock()
+		return nil
+	}
+	a.stopped = true
+	a.mu.Unlock()
+	close(a.stop)
+	<-a.done
+	_, err := a.announce(EventStopped)
+	return err
+}
+
+func (a *Announcer) announce(event Event) (*AnnounceResponse, error) {
+	a.mu.Lock()
+	req := a.req
+	a.mu.Unlock()
+	req.Event = event
+	resp, err := a.tiers.Announce(req)
+	if err != nil {
+		return nil, err
+	}
+	a.pool.Add(SourceTracker, resp.Peers...)
+	return resp, nil
+}
+
+func (a *Announcer) run(interval time.Duration) {
+	defer close(a.done)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-timer.C:
+		}
+		resp, err := a.announce(EventNone)
+		if err != nil {
+			fmt.Println("Reannounce failed:", err)
+			timer.Reset(interval)
+			continue
+		}
+		interval = nextInterval(resp)
+		timer.Reset(interval)
+	}
+}
+
+// nextInterval is the tracker's interval, but never below its min interval.
+func nextInterval(resp *AnnounceResponse) time.Duration {
+	interval := resp.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	if interval < resp.MinInterval {
+		interval = resp.MinInterval
+	}
+	return interval
+}
@@ -1,72 +1,257 @@
 package peers
 
 import (
-	"encoding/binary"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
 	infoCommand "github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/info"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peerid"
 	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
-	"github.com/jackpal/bencode-go"
 )
 
-func FetchPeersFromTracker(trackerURL string, infoHash [20]byte, metadata *torrent.Torrent) ([]string, error) {
-	baseURL, _ := url.Parse(trackerURL)
-	params := url.Values{}
-	params.Add("info_hash", string(infoHash[:]))
-	params.Add("peer_id", "tgtwvrxkbjmspmivqnsj")
-	params.Add("port", strconv.Itoa(6881))
-	params.Add("uploaded", "48")
-	params.Add("downloaded", "48")
+// trackerClient bounds each announce so a dead tracker doesn't stall the
+// failover to the next one in its tier.
+var trackerClient = &http.Client{Timeout: 15 * time.Second}
+
+// TrackerError is a failure reported by the tracker itself, as opposed to
+// one reaching it: the HTTP "failure reason" or a BEP 15 error action.
+type TrackerError struct {
+	Tracker string
+	Reason  string
+}
+
+func (e *TrackerError) Error() string {
+	return fmt.Sprintf("tracker %s failed: %s", e.Tracker, e.Reason)
+}
+
+// Event is the announce event of BEP 3. The values are the BEP 15 codes.
+type Event int
+
+const (
+	EventNone Event = iota
+	EventCompleted
+	EventStarted
+	EventStopped
+)
+
+func (e Event) String() string {
+	switch e {
+	case EventCompleted:
+		return "completed"
+	case EventStarted:
+		return "started"
+	case EventStopped:
+		return "stopped"
+	}
+	return ""
+}
+
+// AnnounceRequest is what the client tells a tracker about itself.
+type AnnounceRequest struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Port       int
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	// Key lets the tracker recognise the client across IP changes.
+	Key uint32
+	// NumWant is how many peers to ask for; negative leaves it to the tracker.
+	NumWant int
+	// TrackerID is echoed back to a tracker that handed one out.
+	TrackerID string
+	// IPv6 is the client's global IPv6 address, sent per BEP 7 so IPv4
+	// trackers can hand it to IPv6 peers.
+	IPv6 net.IP
+}
+
+// AnnounceResponse is a tracker's answer to an announce.
+type AnnounceResponse struct {
+	Peers []string
+	// Interval is how long to wait before the next regular announce and
+	// MinInterval, when the tracker gives one, the least it accepts.
+	Interval    time.Duration
+	MinInterval time.Duration
+	// Warning is a message the tracker wants shown; the announce still succeeded.
+	Warning   string
+	TrackerID string
+	// Seeders and Leechers are the tracker's counts for the swarm, when given.
+	Seeders  int
+	Leechers int
+}
+
+// DefaultPort is the peer port announced when the caller has not set one.
+const DefaultPort = 6881
+
+// NewAnnounceRequest describes a client that has nothing of the torrent yet.
+func NewAnnounceRequest(infoHash [20]byte, metadata *torrent.Torrent) AnnounceRequest {
+	req := AnnounceRequest{InfoHash: infoHash, PeerID: peerid.ID(), Port: DefaultPort, Left: 999, NumWant: -1, IPv6: hostIPv6()}
 	if metadata != nil {
-		params.Add("left", strconv.Itoa(metadata.Info.Length))
-	} else {
-		params.Add("left", strconv.Itoa(999))
+		req.Left = int64(metadata.Info.TotalLength())
+	}
+	return req
+}
 
+// Announce sends req to one tracker, over UDP (BEP 15) for udp:// URLs and
+// HTTP otherwise.
+func Announce(trackerURL string, req AnnounceRequest) (*AnnounceResponse, error) {
+	baseURL, err := url.Parse(trackerURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing trackerURL %s: %v", trackerURL, err)
+	}
+	if baseURL.Scheme == "udp" {
+		return announceUDP(baseURL, req)
+	}
+	return announceHTTP(baseURL, req)
+}
+
+func FetchPeersFromTracker(trackerURL string, infoHash [20]byte, metadata *torrent.Torrent) ([]string, error) {
+	resp, err := Announce(trackerURL, NewAnnounceRequest(infoHash, metadata))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Peers, nil
+}
+
+func announceHTTP(baseURL *url.URL, req AnnounceRequest) (*AnnounceResponse, error) {
+	trackerURL := baseURL.String()
+	params := baseURL.Query()
+	params.Set("info_hash", string(req.InfoHash[:]))
+	params.Set("peer_id", string(req.PeerID[:]))
+	params.Set("port", strconv.Itoa(req.Port))
+	params.Set("uploaded", strconv.FormatInt(req.Uploaded, 10))
+	params.Set("downloaded", strconv.FormatInt(req.Downloaded, 10))
+	params.Set("left", strconv.FormatInt(req.Left, 10))
+	params.Set("compact", "1")
+	if req.Event != EventNone {
+		params.Set("event", req.Event.String())
+	}
+	if req.Key != 0 {
+		params.Set("key", fmt.Sprintf("%08x", req.Key))
+	}
+	if req.NumWant >= 0 {
+		params.Set("numwant", strconv.Itoa(req.NumWant))
+	}
+	if req.TrackerID != "" {
+		params.Set("trackerid", req.TrackerID)
+	}
+	if req.IPv6 != nil {
+		params.Set("ipv6", req.IPv6.String())
 	}
-	params.Add("compact", "1")
 	baseURL.RawQuery = params.Encode()
 
-	resp, err := http.Get(baseURL.String())
+	resp, err := trackerClient.Get(baseURL.String())
 	if err != nil {
 		return nil, fmt.Errorf("error fetching trackerURL %s: %v", trackerURL, err)
 	}
 	defer resp.Body.Close()
 
 	trackerStruct := torrent.TrackerResponse{}
-	if err := bencode.Unmarshal(resp.Body, &trackerStruct); err != nil {
+	if err := bencode.NewDecoder(resp.Body).Decode(&trackerStruct); err != nil {
 		return nil, fmt.Errorf("error unmarshalling tracker response: %v", err)
 	}
 
-	peerData := []byte(trackerStruct.Peers)
-	var peers []string
-	for i := 0; i < len(peerData); i += 6 {
-		ip := fmt.Sprintf("%d.%d.%d.%d", peerData[i], peerData[i+1], peerData[i+2], peerData[i+3])
-		port := binary.BigEndian.Uint16(peerData[i+4 : i+6])
-		peers = append(peers, fmt.Sprintf("%s:%d", ip, port))
+	if trackerStruct.FailureReason != "" {
+		return nil, &TrackerError{Tracker: trackerURL, Reason: trackerStruct.FailureReason}
 	}
-	return peers, nil
+
+	return &AnnounceResponse{
+		Peers:       append(trackerPeers(trackerStruct.Peers), parseCompactPeers([]byte(trackerStruct.Peers6), 18)...),
+		Interval:    time.Duration(trackerStruct.Interval) * time.Second,
+		MinInterval: time.Duration(trackerStruct.MinInterval) * time.Second,
+		Warning:     trackerStruct.WarningMessage,
+		TrackerID:   trackerStruct.TrackerID,
+		Seeders:     trackerStruct.Complete,
+		Leechers:    trackerStruct.Incomplete,
+	}, nil
 }
 
-func PeersCommand(bencodedValue string) []string {
-	metadata, err := infoCommand.LoadTorrentFile(bencodedValue)
+// trackerPeers turns either peer model into host:port addresses.
+func trackerPeers(peers torrent.TrackerPeers) []string {
+	if peers.List == nil {
+		return parseCompactPeers([]byte(peers.Compact), 6)
+	}
+	var addrs []string
+	for _, peer := range peers.List {
+		if peer.IP == "" || peer.Port <= 0 || peer.Port > 65535 {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(peer.IP, strconv.Itoa(peer.Port)))
+	}
+	return addrs
+}
+
+// FetchSwarmPeers asks the trackers for peers under each of the torrent's
+// swarm hashes in turn and returns a pool for the first swarm that has any;
+// the pool's info hash is the one to use in the handshake. Hybrid torrents
+// try the v1 swarm first and fall back to the v2 one. The pool carries the
+// torrent's private flag, so later peer sources are checked against it.
+func FetchSwarmPeers(metadata *torrent.Torrent) (*Pool, error) {
+	hashes, err := infoCommand.SwarmHashes(metadata)
 	if err != nil {
-		fmt.Println(err)
-		return []string{}
+		return nil, err
 	}
-	infoHash, err := infoCommand.GenerateInfoHash(metadata.Info)
+	tiers := NewTiers(metadata.Trackers())
+	var lastErr error
+	for _, infoHash := range hashes {
+		peers, err := tiers.FetchPeers(infoHash, metadata)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(peers) > 0 {
+			pool := NewPool(infoHash, metadata.Info.IsPrivate())
+			pool.Add(SourceTracker, peers...)
+			return pool, nil
+		}
+	}
+	return NewPool(hashes[0], metadata.Info.IsPrivate()), lastErr
+}
+
+func PeersCommand(bencodedValue string) []string {
+	metadata, err := infoCommand.LoadTorrentFile(bencodedValue)
 	if err != nil {
 		fmt.Println(err)
 		return []string{}
 	}
 
-	peers, err := FetchPeersFromTracker(metadata.Announce, infoHash, metadata)
+	pool, err := FetchSwarmPeers(metadata)
 	if err != nil {
 		fmt.Println(err)
 		return []string{}
 	}
+	peers := pool.Peers()
 	fmt.Println("Peers:", peers)
 	return peers
 }
+
+// hostIPv6 looks the address up once per process rather than on every
+// announce.
+var hostIPv6 = sync.OnceValue(localIPv6)
+
+// localIPv6 returns a global unicast IPv6 address of this host, or nil if it
+// has none. Unique local and link-local addresses are of no use to peers
+// elsewhere.
+func localIPv6() net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() != nil {
+			continue
+		}
+		if ip := ipNet.IP; ip.IsGlobalUnicast() && !ip.IsPrivate() {
+			return ip
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package peers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+// startHTTPTracker answers every announce with body and passes the query
+// of each request to queries.
+func startHTTPTracker(t *testing.T, body string) (string, chan url.Values) {
+	t.Helper()
+	queries := make(chan url.Values, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/announce", queries
+}
+
+func TestHTTPAnnounceFailureReason(t *testing.T) {
+	trackerURL, _ := startHTTPTracker(t, "d14:failure reason17:torrent not founde")
+	resp, err := Announce(trackerURL, AnnounceRequest{InfoHash: [20]byte{1}, NumWant: -1})
+	var trackerErr *TrackerError
+	if !errors.As(err, &trackerErr) {
+		t.Fatalf("announce = %+v, %v, want a TrackerError", resp, err)
+	}
+	if trackerErr.Reason != "torrent not found" || trackerErr.Tracker != trackerURL {
+		t.Fatalf("error %+v", trackerErr)
+	}
+
+	// a failure reads the same whether or not the tracker adds other keys
+	trackerURL, _ = startHTTPTracker(t, "d14:failure reason6:banned8:intervali1800e5:peers6:\x0a\x00\x00\x01\x1a\xe1e")
+	if _, err := FetchPeersFromTracker(trackerURL, [20]byte{1}, nil); !errors.As(err, &trackerErr) || trackerErr.Reason != "banned" {
+		t.Fatalf("peers fetched despite the failure: %v", err)
+	}
+}
+
+func TestHTTPAnnounceResponseFields(t *testing.T) {
+	trackerURL, _ := startHTTPTracker(t, "d8:completei12e10:incompletei3e8:intervali1800e12:min intervali60e"+
+		"5:peers6:\x0a\x00\x00\x01\x1a\xe16:peers618:\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe2"+
+		"10:tracker id3:abc15:warning message9:slow downe")
+	resp, err := Announce(trackerURL, AnnounceRequest{InfoHash: [20]byte{1}, NumWant: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AnnounceResponse{
+		Peers:       []string{"10.0.0.1:6881", "[2001:db8::1]:6882"},
+		Interval:    30 * time.Minute,
+		MinInterval: time.Minute,
+		Warning:     "slow down",
+		TrackerID:   "abc",
+		Seeders:     12,
+		Leechers:    3,
+	}
+	if !slices.Equal(resp.Peers, want.Peers) {
+		t.Errorf("peers %v, want %v", resp.Peers, want.Peers)
+	}
+	resp.Peers = want.Peers
+	if resp.Interval != want.Interval || resp.MinInterval != want.MinInterval || resp.Warning != want.Warning ||
+		resp.TrackerID != want.TrackerID || resp.Seeders != want.Seeders || resp.Leechers != want.Leechers {
+		t.Errorf("response %+v, want %+v", *resp, want)
+	}
+}
+
+func TestHTTPAnnounceDictPeers(t *testing.T) {
+	trackerURL, _ := startHTTPTracker(t, "d8:intervali1800e5:peersl"+
+		"d2:ip8:10.0.0.17:peer id20:-XX0001-aaaaaaaaaaaa4:porti6881ee"+
+		"d2:ip11:2001:db8::24:porti6882ee"+
+		"d2:ip8:10.0.0.34:porti0ee"+
+		"d4:porti6883ee"+
+		"ee")
+	peerList, err := FetchPeersFromTracker(trackerURL, [20]byte{1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// entries without an address or a usable port are skipped
+	if want := []string{"10.0.0.1:6881", "[2001:db8::2]:6882"}; !slices.Equal(peerList, want) {
+		t.Fatalf("peers %v, want %v", peerList, want)
+	}
+}
+
+func TestHTTPAnnounceRequest(t *testing.T) {
+	trackerURL, queries := startHTTPTracker(t, "d8:intervali1800e5:peers0:e")
+	req := AnnounceRequest{
+		InfoHash:   [20]byte{1, 2, 3},
+		PeerID:     [20]byte{'-', 'T'},
+		Port:       6881,
+		Uploaded:   10,
+		Downloaded: 20,
+		Left:       30,
+		Event:      EventCompleted,
+		Key:        0xbeef,
+		NumWant:    0,
+		TrackerID:  "abc",
+	}
+	if _, err := Announce(trackerURL+"?passkey=secret", req); err != nil {
+		t.Fatal(err)
+	}
+	query := <-queries
+	want := map[string]string{
+		"info_hash":  string(req.InfoHash[:]),
+		"peer_id":    string(req.PeerID[:]),
+		"port":       "6881",
+		"uploaded":   "10",
+		"downloaded": "20",
+		"left":       "30",
+		"compact":    "1",
+		"event":      "completed",
+		"key":        "0000beef",
+		"numwant":    "0",
+		"trackerid":  "abc",
+		"passkey":    "secret",
+	}
+	for key, value := range want {
+		if query.Get(key) != value {
+			t.Errorf("%s = %q, want %q", key, query.Get(key), value)
+		}
+	}
+	if query.Has("ipv6") {
+		t.Error("ipv6 sent without an address")
+	}
+}
@@ -0,0 +1,137 @@
+package peers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Source identifies where a peer address was learned from.
+type Source int
+
+const (
+	SourceTracker Source = iota
+	SourceDHT
+	SourcePEX
+	SourceLSD
+	SourceIncoming
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceTracker:
+		return "tracker"
+	case SourceDHT:
+		return "dht"
+	case SourcePEX:
+		return "pex"
+	case SourceLSD:
+		return "lsd"
+	case SourceIncoming:
+		return "incoming"
+	}
+	return fmt.Sprintf("source(%d)", int(s))
+}
+
+// ErrPrivateTorrent is returned when a discovery mechanism other than the
+// torrent's own trackers is started for a private torrent.
+var ErrPrivateTorrent = errors.New("peer source disabled for private torrent (BEP 27)")
+
+// Discoverer is a peer source beyond the torrent's trackers, such as DHT,
+// PEX or local service discovery. It is only ever handed the info hash
+// through Pool.Start, so the pool decides whether the hash may leave the
+// process at all.
+type Discoverer interface {
+	Source() Source
+	Discover(infoHash [20]byte, pool *Pool) error
+}
+
+// Pool is the single place peers for a torrent are collected. It enforces
+// BEP 27: for a private torrent only tracker peers (and peers that connected
+// to us) are accepted and no other discovery mechanism is started.
+type Pool struct {
+	mu       sync.Mutex
+	infoHash [20]byte
+	private  bool
+	peers    []string
+	sources  map[string]Source
+}
+
+func NewPool(infoHash [20]byte, private bool) *Pool {
+	return &Pool{infoHash: infoHash, private: private, sources: make(map[string]Source)}
+}
+
+func (p *Pool) InfoHash() [20]byte {
+	return p.infoHash
+}
+
+func (p *Pool) Private() bool {
+	return p.private
+}
+
+// Allows reports whether peers from src may be used for this torrent.
+func (p *Pool) Allows(src Source) bool {
+	return !p.private || src == SourceTracker || src == SourceIncoming
+}
+
+// Add records peer addresses learned from src, ignoring duplicates and
+// anything from a source the torrent doesn't allow. It returns how many new
+// peers were added.
+func (p *Pool) Add(src Source, addrs ...string) int {
+	if !p.Allows(src) {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	added := 0
+	for _, addr := range addrs {
+		if _, ok := p.sources[addr]; ok {
+			continue
+		}
+		p.sources[addr] = src
+		p.peers = append(p.peers, addr)
+		added++
+	}
+	return added
+}
+
+// Remove forgets a peer, e.g. after it was reported dropped.
+func (p *Pool) Remove(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.sources[addr]; !ok {
+		return
+	}
+	delete(p.sources, addr)
+	for i, peer := range p.peers {
+		if peer == addr {
+			p.peers = append(p.peers[:i], p.peers[i+1:]...)
+			break
+		}
+	}
+}
+
+// Peers returns the known peers in the order they were learned.
+func (p *Pool) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.peers...)
+}
+
+// SourceOf reports where addr was learned from.
+func (p *Pool) SourceOf(addr string) (Source, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	src, ok := p.sources[addr]
+	return src, ok
+}
+
+// Start runs a discovery mechanism for the pool's torrent. For private
+// torrents it refuses without calling the discoverer, so the info hash is
+// never announced outside the torrent's trackers.
+func (p *Pool) Start(d Discoverer) error {
+	if !p.Allows(d.Source()) {
+		return ErrPrivateTorrent
+	}
+	return d.Discover(p.infoHash, p)
+}
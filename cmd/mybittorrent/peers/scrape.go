@@ -0,0 +1,227 @@
+package peers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	infoCommand "github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/info"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+// ScrapeStats is a tracker's count of peers in one swarm. Found is false
+// when the tracker doesn't know the info hash. UDP trackers answer zeros for
+// a hash they don't know, so there an empty swarm is not found either.
+type ScrapeStats struct {
+	Seeders   int  `json:"seeders"`
+	Completed int  `json:"completed"`
+	Leechers  int  `json:"leechers"`
+	Found     bool `json:"found"`
+}
+
+// ScrapeURL derives the scrape URL of an HTTP announce URL by the usual
+// convention: the last path segment must start with "announce", which is
+// replaced by "scrape". UDP URLs are returned unchanged.
+func ScrapeURL(announceURL string) (string, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return "", fmt.Errorf("error parsing trackerURL %s: %v", announceURL, err)
+	}
+	if u.Scheme == "udp" {
+		return announceURL, nil
+	}
+	dir, last := path.Split(u.Path)
+	if !strings.HasPrefix(last, "announce") {
+		return "", fmt.Errorf("tracker %s does not support scrape", announceURL)
+	}
+	u.Path = dir + "scrape" + strings.TrimPrefix(last, "announce")
+	return u.String(), nil
+}
+
+// Scrape asks the tracker behind announceURL for the statistics of every
+// info hash in one request and returns them in the same order.
+func Scrape(announceURL string, infoHashes [][20]byte) ([]ScrapeStats, error) {
+	scrapeURL, err := ScrapeURL(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(scrapeURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "udp" {
+		stats, err := scrapeUDP(u, infoHashes)
+		if err != nil {
+			return nil, err
+		}
+		// an unknown hash is all zeros, which can't be told from an empty swarm
+		for i := range stats {
+			stats[i].Found = stats[i].Seeders != 0 || stats[i].Completed != 0 || stats[i].Leechers != 0
+		}
+		return stats, nil
+	}
+	return scrapeHTTP(u, infoHashes)
+}
+
+func scrapeHTTP(scrapeURL *url.URL, infoHashes [][20]byte) ([]ScrapeStats, error) {
+	params := scrapeURL.Query()
+	for _, infoHash := range infoHashes {
+		params.Add("info_hash", string(infoHash[:]))
+	}
+	scrapeURL.RawQuery = params.Encode()
+
+	resp, err := trackerClient.Get(scrapeURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching scrape URL %s: %v", scrapeURL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	scrapeStruct := torrent.ScrapeResponse{}
+	if err := bencode.NewDecoder(resp.Body).Decode(&scrapeStruct); err != nil {
+		return nil, fmt.Errorf("error unmarshalling scrape response: %v", err)
+	}
+	if scrapeStruct.FailureReason != "" {
+		scrapeURL.RawQuery = ""
+		return nil, &TrackerError{Tracker: scrapeURL.String(), Reason: scrapeStruct.FailureReason}
+	}
+
+	stats := make([]ScrapeStats, len(infoHashes))
+	for i, infoHash := range infoHashes {
+		file, ok := scrapeStruct.Files[string(infoHash[:])]
+		if !ok {
+			continue
+		}
+		stats[i] = ScrapeStats{Seeders: file.Complete, Completed: file.Downloaded, Leechers: file.Incomplete, Found: true}
+	}
+	return stats, nil
+}
+
+// ScrapeResult is one line of the scrape command's output.
+type ScrapeResult struct {
+	Name     string `json:"name"`
+	InfoHash string `json:"info_hash"`
+	Tracker  string `json:"tracker"`
+	ScrapeStats
+	Error string `json:"error,omitempty"`
+}
+
+// ScrapeTorrents scrapes every tracker of every torrent, or only tracker
+// when it is set. Torrents sharing a tracker are scraped in one request, and
+// a hybrid torrent has a result for each of its swarms.
+func ScrapeTorrents(torrents []*torrent.Torrent, tracker string) ([]ScrapeResult, error) {
+	var results []ScrapeResult
+	var resultHashes [][20]byte
+	// requests maps a tracker to the indexes of its results, in order
+	requests := make(map[string][]int)
+	var trackers []string
+	for _, metadata := range torrents {
+		hashes, err := infoCommand.SwarmHashes(metadata)
+		if err != nil {
+			return nil, err
+		}
+		trackerURLs := []string{tracker}
+		if tracker == "" {
+			trackerURLs = nil
+			for _, tier := range metadata.Trackers() {
+				trackerURLs = append(trackerURLs, tier...)
+			}
+		}
+		for _, trackerURL := range trackerURLs {
+			if _, ok := requests[trackerURL]; !ok {
+				trackers = append(trackers, trackerURL)
+			}
+			for _, infoHash := range hashes {
+				requests[trackerURL] = append(requests[trackerURL], len(results))
+				resultHashes = append(resultHashes, infoHash)
+				results = append(results, ScrapeResult{
+					Name:     metadata.Info.Name,
+					InfoHash: hex.EncodeToString(infoHash[:]),
+					Tracker:  trackerURL,
+				})
+			}
+		}
+	}
+
+	for _, trackerURL := range trackers {
+		indexes := requests[trackerURL]
+		hashes := make([][20]byte, len(indexes))
+		for i, index := range indexes {
+			hashes[i] = resultHashes[index]
+		}
+		stats, err := Scrape(trackerURL, hashes)
+		for i, index := range indexes {
+			if err != nil {
+				results[index].Error = err.Error()
+				continue
+			}
+			results[index].ScrapeStats = stats[i]
+		}
+	}
+	return results, nil
+}
+
+func ScrapeCommand(args []string) {
+	flags := flag.NewFlagSet("scrape", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print the results as JSON")
+	tracker := flags.String("tracker", "", "scrape this tracker instead of the torrents' own")
+	flags.Usage = func() {
+		fmt.Println("Usage: scrape [options] <torrent file>...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return
+	}
+
+	var torrents []*torrent.Torrent
+	for _, torrentPath := range flags.Args() {
+		metadata, err := infoCommand.LoadTorrentFile(torrentPath)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		torrents = append(torrents, metadata)
+	}
+	results, err := ScrapeTorrents(torrents, *tracker)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if *jsonOutput {
+		if results == nil {
+			results = []ScrapeResult{}
+		}
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tINFO HASH\tTRACKER\tSEEDERS\tLEECHERS\tCOMPLETED\t")
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\terror: %s\n", result.Name, result.InfoHash, result.Tracker, result.Error)
+		case !result.Found:
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\tnot tracked\n", result.Name, result.InfoHash, result.Tracker)
+		default:
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t\n", result.Name, result.InfoHash, result.Tracker, result.Seeders, result.Leechers, result.Completed)
+		}
+	}
+	w.Flush()
+}
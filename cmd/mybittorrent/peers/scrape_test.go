@@ -0,0 +1,43 @@
+package peers
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+func TestScrapeHybridTorrent(t *testing.T) {
+	tracker := startFakeUDPTracker(t)
+	infoBytes := []byte("d4:name6:hybride")
+	v1 := sha1.Sum(infoBytes)
+	v2 := sha256.Sum256(infoBytes)
+	// only the v2 swarm has peers on this tracker
+	tracker.mu.Lock()
+	tracker.seeders[[20]byte(v2[:20])] = 4
+	tracker.mu.Unlock()
+
+	metadata := &torrent.Torrent{
+		Announce:  tracker.URL(),
+		Info:      torrent.InfoData{Name: "hybrid", Pieces: "x", MetaVersion: 2},
+		InfoBytes: infoBytes,
+	}
+	results, err := ScrapeTorrents([]*torrent.Torrent{metadata}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("%d results, want one per swarm", len(results))
+	}
+	if results[0].InfoHash != hex.EncodeToString(v1[:]) || results[0].Found {
+		t.Errorf("v1 result = %+v, want the empty v1 swarm reported as not found", results[0])
+	}
+	if results[1].InfoHash != hex.EncodeToString(v2[:20]) || !results[1].Found || results[1].Seeders != 4 {
+		t.Errorf("v2 result = %+v, want 4 seeders in the v2 swarm", results[1])
+	}
+	if _, packets := tracker.stats(); packets != 2 {
+		t.Errorf("tracker saw %d packets, want a connect and one scrape for both hashes", packets)
+	}
+}
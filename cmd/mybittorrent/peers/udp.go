@@ -0,0 +1,299 @@
+package peers
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// BEP 15 actions.
+const (
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+)
+
+const udpProtocolID = 0x41727101980
+
+// udpConnectionLifetime is how long a tracker accepts a connection id, and so
+// how long it is cached for.
+const udpConnectionLifetime = time.Minute
+
+var (
+	// UDPBaseTimeout and UDPMaxRetries give the BEP 15 retransmission
+	// schedule: attempt n waits UDPBaseTimeout * 2^n, for n up to
+	// UDPMaxRetries, so a dead tracker is given up on after about two hours.
+	UDPBaseTimeout = 15 * time.Second
+	UDPMaxRetries  = 8
+	// UDPTimeout, when set, bounds a whole request, connect and
+	// retransmissions included, for callers that can't wait out the
+	// schedule; the commands set it like the HTTP client's timeout.
+	UDPTimeout time.Duration
+)
+
+// udpWait is how long attempt n waits for a response.
+func udpWait(n int) time.Duration {
+	return UDPBaseTimeout << n
+}
+
+type udpConnection struct {
+	id       uint64
+	obtained time.Time
+}
+
+// udpConnections caches connection ids by tracker address.
+var udpConnections = struct {
+	sync.Mutex
+	ids map[string]udpConnection
+}{ids: make(map[string]udpConnection)}
+
+type udpTracker struct {
+	conn *net.UDPConn
+	addr string
+	// peerSize is 6 for IPv4 trackers and 18 for IPv6 ones; BEP 15 ties the
+	// compact peer format to the address family of the tracker.
+	peerSize int
+}
+
+func dialUDPTracker(trackerURL *url.URL) (*udpTracker, error) {
+	addr, err := net.ResolveUDPAddr("udp", trackerURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving tracker %s: %v", trackerURL.Host, err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to tracker %s: %v", trackerURL.Host, err)
+	}
+	tracker := &udpTracker{conn: conn, addr: addr.String(), peerSize: 6}
+	if addr.IP.To4() == nil {
+		tracker.peerSize = 18
+	}
+	return tracker, nil
+}
+
+func (t *udpTracker) Close() error {
+	return t.conn.Close()
+}
+
+func transactionID() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// roundTrip sends request on attempt n of the retransmission schedule and
+// waits for the matching response, but not past deadline unless it is zero.
+// A timeout error means nothing arrived in time and the caller may retry.
+func (t *udpTracker) roundTrip(request []byte, action uint32, n int, deadline time.Time) ([]byte, error) {
+	transaction := binary.BigEndian.Uint32(request[12:16])
+	if _, err := t.conn.Write(request); err != nil {
+		return nil, err
+	}
+	wait := time.Now().Add(udpWait(n))
+	if !deadline.IsZero() && wait.After(deadline) {
+		wait = deadline
+	}
+	t.conn.SetReadDeadline(wait)
+	buf := make([]byte, 65536)
+	for {
+		length, err := t.conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		response := buf[:length]
+		// stray or late packets for other transactions are ignored
+		if length < 8 || binary.BigEndian.Uint32(response[4:8]) != transaction {
+			continue
+		}
+		switch binary.BigEndian.Uint32(response[0:4]) {
+		case action:
+			return response, nil
+		case udpActionError:
+			return nil, &TrackerError{Tracker: "udp://" + t.addr, Reason: string(response[8:])}
+		}
+		return nil, fmt.Errorf("unexpected action %d in tracker response", binary.BigEndian.Uint32(response[0:4]))
+	}
+}
+
+// connect returns a connection id for the tracker, reusing a cached one
+// while it is still valid; cached reports which it did.
+func (t *udpTracker) connect(n int, deadline time.Time) (id uint64, cached bool, err error) {
+	udpConnections.Lock()
+	connection, ok := udpConnections.ids[t.addr]
+	udpConnections.Unlock()
+	if ok && time.Since(connection.obtained) < udpConnectionLifetime {
+		return connection.id, true, nil
+	}
+
+	request := make([]byte, 16)
+	binary.BigEndian.PutUint64(request[0:8], udpProtocolID)
+	binary.BigEndian.PutUint32(request[8:12], udpActionConnect)
+	binary.BigEndian.PutUint32(request[12:16], transactionID())
+	response, err := t.roundTrip(request, udpActionConnect, n, deadline)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(response) < 16 {
+		return 0, false, fmt.Errorf("short connect response from tracker")
+	}
+	id = binary.BigEndian.Uint64(response[8:16])
+	udpConnections.Lock()
+	udpConnections.ids[t.addr] = udpConnection{id: id, obtained: time.Now()}
+	udpConnections.Unlock()
+	return id, false, nil
+}
+
+func (t *udpTracker) forgetConnection() {
+	udpConnections.Lock()
+	delete(udpConnections.ids, t.addr)
+	udpConnections.Unlock()
+}
+
+// exchange sends body, everything after the 16 byte header, as an action
+// that needs a connection id. The connect and the request itself both follow
+// the retransmission schedule, within UDPTimeout in all when it is set. A
+// cached connection id the tracker rejects is replaced once with a fresh one.
+func (t *udpTracker) exchange(action uint32, body []byte) ([]byte, error) {
+	var deadline time.Time
+	if UDPTimeout > 0 {
+		deadline = time.Now().Add(UDPTimeout)
+	}
+	reconnected := false
+	var err error
+	for n := 0; n <= UDPMaxRetries && (deadline.IsZero() || time.Now().Before(deadline)); n++ {
+		var id uint64
+		var cached bool
+		id, cached, err = t.connect(n, deadline)
+		if err != nil {
+			if isTimeout(err) {
+				continue
+			}
+			return nil, err
+		}
+		request := make([]byte, 16+len(body))
+		binary.BigEndian.PutUint64(request[0:8], id)
+		binary.BigEndian.PutUint32(request[8:12], action)
+		binary.BigEndian.PutUint32(request[12:16], transactionID())
+		copy(request[16:], body)
+		var response []byte
+		response, err = t.roundTrip(request, action, n, deadline)
+		if err == nil {
+			return response, nil
+		}
+		if isTimeout(err) {
+			continue
+		}
+		// the tracker may have rejected an expired connection id
+		t.forgetConnection()
+		var trackerErr *TrackerError
+		if cached && !reconnected && errors.As(err, &trackerErr) {
+			reconnected = true
+			n--
+			continue
+		}
+		return nil, err
+	}
+	return nil, fmt.Errorf("tracker %s did not respond: %v", t.addr, err)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func announceUDP(trackerURL *url.URL, req AnnounceRequest) (*AnnounceResponse, error) {
+	tracker, err := dialUDPTracker(trackerURL)
+	if err != nil {
+		return nil, err
+	}
+	defer tracker.Close()
+
+	body := make([]byte, 82)
+	copy(body[0:20], req.InfoHash[:])
+	copy(body[20:40], req.PeerID[:])
+	binary.BigEndian.PutUint64(body[40:48], uint64(req.Downloaded))
+	binary.BigEndian.PutUint64(body[48:56], uint64(req.Left))
+	binary.BigEndian.PutUint64(body[56:64], uint64(req.Uploaded))
+	binary.BigEndian.PutUint32(body[64:68], uint32(req.Event))
+	binary.BigEndian.PutUint32(body[68:72], 0) // IP: the sender's
+	binary.BigEndian.PutUint32(body[72:76], req.Key)
+	binary.BigEndian.PutUint32(body[76:80], uint32(int32(req.NumWant)))
+	binary.BigEndian.PutUint16(body[80:82], uint16(req.Port))
+
+	response, err := tracker.exchange(udpActionAnnounce, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 20 {
+		return nil, fmt.Errorf("short announce response from tracker")
+	}
+	return &AnnounceResponse{
+		Peers:    parseCompactPeers(response[20:], tracker.peerSize),
+		Interval: time.Duration(binary.BigEndian.Uint32(response[8:12])) * time.Second,
+		Leechers: int(binary.BigEndian.Uint32(response[12:16])),
+		Seeders:  int(binary.BigEndian.Uint32(response[16:20])),
+	}, nil
+}
+
+// udpMaxScrape is how many info hashes fit in one scrape; BEP 15 caps the
+// response to what a single packet holds.
+const udpMaxScrape = 74
+
+func scrapeUDP(trackerURL *url.URL, infoHashes [][20]byte) ([]ScrapeStats, error) {
+	if len(infoHashes) > udpMaxScrape {
+		first, err := scrapeUDP(trackerURL, infoHashes[:udpMaxScrape])
+		if err != nil {
+			return nil, err
+		}
+		rest, err := scrapeUDP(trackerURL, infoHashes[udpMaxScrape:])
+		if err != nil {
+			return nil, err
+		}
+		return append(first, rest...), nil
+	}
+	tracker, err := dialUDPTracker(trackerURL)
+	if err != nil {
+		return nil, err
+	}
+	defer tracker.Close()
+
+	body := make([]byte, 0, 20*len(infoHashes))
+	for _, infoHash := range infoHashes {
+		body = append(body, infoHash[:]...)
+	}
+	response, err := tracker.exchange(udpActionScrape, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 8+12*len(infoHashes) {
+		return nil, fmt.Errorf("short scrape response from tracker")
+	}
+	stats := make([]ScrapeStats, len(infoHashes))
+	for i := range stats {
+		entry := response[8+12*i:]
+		stats[i] = ScrapeStats{
+			Seeders:   int(binary.BigEndian.Uint32(entry[0:4])),
+			Completed: int(binary.BigEndian.Uint32(entry[4:8])),
+			Leechers:  int(binary.BigEndian.Uint32(entry[8:12])),
+		}
+	}
+	return stats, nil
+}
+
+// parseCompactPeers reads peers packed as address and big-endian port,
+// 6 bytes each for IPv4 and 18 for IPv6.
+func parseCompactPeers(data []byte, size int) []string {
+	var peers []string
+	for i := 0; i+size <= len(data); i += size {
+		ip := net.IP(data[i : i+size-2])
+		port := binary.BigEndian.Uint16(data[i+size-2 : i+size])
+		peers = append(peers, net.JoinHostPort(ip.String(), fmt.Sprint(port)))
+	}
+	return peers
+}
@@ -0,0 +1,299 @@
+package peers
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUDPTracker is a BEP 15 tracker on localhost that records what it is
+// sent and can be told to drop packets or forget connection ids.
+type fakeUDPTracker struct {
+	conn *net.UDPConn
+
+	mu       sync.Mutex
+	nextID   uint64
+	valid    map[uint64]bool
+	connects int
+	packets  int
+	// arrivals are the times packets came in
+	arrivals []time.Time
+	// drop is how many more packets to ignore.
+	drop      int
+	announces [][]byte
+	// seeders per info hash, for scrapes
+	seeders map[[20]byte]uint32
+	peers   []byte
+}
+
+func startFakeUDPTracker(t *testing.T) *fakeUDPTracker {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeUDPTracker{conn: conn, nextID: 1000, valid: make(map[uint64]bool), seeders: make(map[[20]byte]uint32)}
+	t.Cleanup(func() { conn.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeUDPTracker) URL() string {
+	return "udp://" + f.conn.LocalAddr().String() + "/announce"
+}
+
+func (f *fakeUDPTracker) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if reply := f.handle(append([]byte(nil), buf[:n]...)); reply != nil {
+			f.conn.WriteToUDP(reply, from)
+		}
+	}
+}
+
+func (f *fakeUDPTracker) handle(packet []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.packets++
+	f.arrivals = append(f.arrivals, time.Now())
+	if f.drop > 0 {
+		f.drop--
+		return nil
+	}
+	if len(packet) < 16 {
+		return nil
+	}
+	id := binary.BigEndian.Uint64(packet[0:8])
+	action := binary.BigEndian.Uint32(packet[8:12])
+	reply := binary.BigEndian.AppendUint32(nil, action)
+	reply = append(reply, packet[12:16]...)
+	if action == udpActionConnect {
+		if id != udpProtocolID {
+			return nil
+		}
+		f.connects++
+		f.nextID++
+		f.valid[f.nextID] = true
+		return binary.BigEndian.AppendUint64(reply, f.nextID)
+	}
+	if !f.valid[id] {
+		binary.BigEndian.PutUint32(reply[0:4], udpActionError)
+		return append(reply, "invalid connection id"...)
+	}
+	switch action {
+	case udpActionAnnounce:
+		f.announces = append(f.announces, packet[16:])
+		reply = binary.BigEndian.AppendUint32(reply, 1800)
+		reply = binary.BigEndian.AppendUint32(reply, 3)
+		reply = binary.BigEndian.AppendUint32(reply, 7)
+		return append(reply, f.peers...)
+	case udpActionScrape:
+		for i := 16; i+20 <= len(packet); i += 20 {
+			var infoHash [20]byte
+			copy(infoHash[:], packet[i:i+20])
+			reply = binary.BigEndian.AppendUint32(reply, f.seeders[infoHash])
+			reply = binary.BigEndian.AppendUint32(reply, 0)
+			reply = binary.BigEndian.AppendUint32(reply, 0)
+		}
+		return reply
+	}
+	return nil
+}
+
+// forgetIDs makes the tracker reject every connection id handed out so far,
+// as it does once they expire.
+func (f *fakeUDPTracker) forgetIDs() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.valid = make(map[uint64]bool)
+}
+
+func (f *fakeUDPTracker) stats() (connects, packets int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connects, f.packets
+}
+
+func shortenUDPTimeouts(t *testing.T, base, total time.Duration) {
+	oldBase, oldTotal := UDPBaseTimeout, UDPTimeout
+	UDPBaseTimeout, UDPTimeout = base, total
+	t.Cleanup(func() { UDPBaseTimeout, UDPTimeout = oldBase, oldTotal })
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	tracker := startFakeUDPTracker(t)
+	tracker.mu.Lock()
+	tracker.peers = []byte{10, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0x1a, 0xe2}
+	tracker.mu.Unlock()
+
+	req := AnnounceRequest{InfoHash: [20]byte{1, 2, 3}, PeerID: [20]byte{'-', 'T'}, Port: 6881, Left: 1234, Downloaded: 5, Event: EventStarted, Key: 42, NumWant: -1}
+	resp, err := Announce(tracker.URL(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1:6881", "10.0.0.2:6882"}; !reflect.DeepEqual(resp.Peers, want) {
+		t.Errorf("peers = %v, want %v", resp.Peers, want)
+	}
+	if resp.Interval != 1800*time.Second || resp.Leechers != 3 || resp.Seeders != 7 {
+		t.Errorf("got interval %v, %d leechers, %d seeders", resp.Interval, resp.Leechers, resp.Seeders)
+	}
+
+	tracker.mu.Lock()
+	body := tracker.announces[0]
+	tracker.mu.Unlock()
+	if [20]byte(body[0:20]) != req.InfoHash || [20]byte(body[20:40]) != req.PeerID {
+		t.Error("info hash or peer id not sent")
+	}
+	if binary.BigEndian.Uint64(body[40:48]) != 5 || binary.BigEndian.Uint64(body[48:56]) != 1234 {
+		t.Error("downloaded or left not sent")
+	}
+	if Event(binary.BigEndian.Uint32(body[64:68])) != EventStarted || binary.BigEndian.Uint32(body[72:76]) != 42 {
+		t.Error("event or key not sent")
+	}
+	if int32(binary.BigEndian.Uint32(body[76:80])) != -1 || binary.BigEndian.Uint16(body[80:82]) != 6881 {
+		t.Error("num want or port not sent")
+	}
+}
+
+func TestUDPConnectionIDReuseAndExpiry(t *testing.T) {
+	tracker := startFakeUDPTracker(t)
+	req := AnnounceRequest{InfoHash: [20]byte{1}, NumWant: -1}
+	for i := 0; i < 3; i++ {
+		if _, err := Announce(tracker.URL(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if connects, _ := tracker.stats(); connects != 1 {
+		t.Fatalf("%d connects for three announces, want 1", connects)
+	}
+
+	// once the cached id is older than its lifetime a new one is fetched
+	addr := tracker.conn.LocalAddr().String()
+	udpConnections.Lock()
+	connection := udpConnections.ids[addr]
+	connection.obtained = time.Now().Add(-udpConnectionLifetime)
+	udpConnections.ids[addr] = connection
+	udpConnections.Unlock()
+	if _, err := Announce(tracker.URL(), req); err != nil {
+		t.Fatal(err)
+	}
+	if connects, _ := tracker.stats(); connects != 2 {
+		t.Fatalf("%d connects after the id expired, want 2", connects)
+	}
+}
+
+func TestUDPRejectedConnectionID(t *testing.T) {
+	tracker := startFakeUDPTracker(t)
+	req := AnnounceRequest{InfoHash: [20]byte{1}, NumWant: -1}
+	if _, err := Announce(tracker.URL(), req); err != nil {
+		t.Fatal(err)
+	}
+	// the tracker expired the id earlier than the client expected
+	tracker.forgetIDs()
+	if _, err := Announce(tracker.URL(), req); err != nil {
+		t.Fatalf("announce with a rejected cached id: %v", err)
+	}
+	if connects, _ := tracker.stats(); connects != 2 {
+		t.Fatalf("%d connects, want a reconnect after the rejection", connects)
+	}
+}
+
+func TestUDPRetransmission(t *testing.T) {
+	shortenUDPTimeouts(t, 20*time.Millisecond, 5*time.Second)
+	tracker := startFakeUDPTracker(t)
+	// lose the first connect and its retransmission
+	tracker.mu.Lock()
+	tracker.drop = 2
+	tracker.mu.Unlock()
+	if _, err := Announce(tracker.URL(), AnnounceRequest{InfoHash: [20]byte{1}, NumWant: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, packets := tracker.stats(); packets != 4 {
+		t.Fatalf("tracker saw %d packets, want 2 lost connects, a connect and an announce", packets)
+	}
+}
+
+func TestUDPRetransmissionSchedule(t *testing.T) {
+	// BEP 15: 15 * 2^n seconds, n up to 8, with no overall cap unless a
+	// command asks for one
+	if UDPMaxRetries != 8 || UDPTimeout != 0 {
+		t.Fatalf("max retries %d, timeout %v, want 8 and none", UDPMaxRetries, UDPTimeout)
+	}
+	for n := 0; n <= UDPMaxRetries; n++ {
+		if want := time.Duration(15<<n) * time.Second; udpWait(n) != want {
+			t.Errorf("attempt %d waits %v, want %v", n, udpWait(n), want)
+		}
+	}
+
+	// the schedule in use, scaled down: every attempt is made and each
+	// waits twice as long as the one before
+	shortenUDPTimeouts(t, 2*time.Millisecond, 0)
+	tracker := startFakeUDPTracker(t)
+	tracker.mu.Lock()
+	tracker.drop = 1 << 30
+	tracker.mu.Unlock()
+	if _, err := Announce(tracker.URL(), AnnounceRequest{InfoHash: [20]byte{1}, NumWant: -1}); err == nil {
+		t.Fatal("announce to a silent tracker succeeded")
+	}
+	tracker.mu.Lock()
+	arrivals := tracker.arrivals
+	tracker.mu.Unlock()
+	if len(arrivals) != UDPMaxRetries+1 {
+		t.Fatalf("%d attempts, want %d", len(arrivals), UDPMaxRetries+1)
+	}
+	for n := 1; n < len(arrivals); n++ {
+		if gap := arrivals[n].Sub(arrivals[n-1]); gap < udpWait(n-1) {
+			t.Errorf("attempt %d sent %v after the one before, want at least %v", n, gap, udpWait(n-1))
+		}
+	}
+}
+
+func TestUDPTimeoutBudget(t *testing.T) {
+	shortenUDPTimeouts(t, 20*time.Millisecond, 300*time.Millisecond)
+	tracker := startFakeUDPTracker(t)
+	tracker.mu.Lock()
+	tracker.drop = 1 << 30
+	tracker.mu.Unlock()
+	start := time.Now()
+	_, err := Announce(tracker.URL(), AnnounceRequest{InfoHash: [20]byte{1}, NumWant: -1})
+	if err == nil || !strings.Contains(err.Error(), "did not respond") {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("gave up after %v, want about 300ms", elapsed)
+	}
+	if _, packets := tracker.stats(); packets < 3 {
+		t.Fatalf("only %d packets sent, want retransmissions", packets)
+	}
+}
+
+func TestUDPScrape(t *testing.T) {
+	tracker := startFakeUDPTracker(t)
+	hashes := make([][20]byte, udpMaxScrape+3)
+	tracker.mu.Lock()
+	for i := range hashes {
+		hashes[i][0], hashes[i][1] = byte(i), 0xaa
+		tracker.seeders[hashes[i]] = uint32(i + 1)
+	}
+	tracker.mu.Unlock()
+	stats, err := Scrape(tracker.URL(), hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != len(hashes) {
+		t.Fatalf("%d results for %d hashes", len(stats), len(hashes))
+	}
+	for i, s := range stats {
+		if s.Seeders != i+1 {
+			t.Fatalf("hash %d: %d seeders, want %d", i, s.Seeders, i+1)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+// File is one file of a torrent laid out in the concatenated piece space.
+type File struct {
+	Path string
+	// TorrentPath is the file's path inside the torrent, starting with the
+	// torrent name, as used to address it on a web seed.
+	TorrentPath []string
+	Length      int
+	Offset      int
+	// Pad files only align the next file to a piece boundary and are never
+	// touched on disk.
+	Pad bool
+}
+
+// Span is the part of a piece that falls inside a single file.
+type Span struct {
+	File        *File
+	FileOffset  int
+	PieceOffset int
+	Length      int
+}
+
+// Layout maps piece indexes onto the files they cover.
+type Layout struct {
+	Files       []File
+	PieceLength int
+	TotalLength int
+	info        *torrent.InfoData
+}
+
+// NewLayout builds the file layout for info. Single-file torrents are written
+// to downloadPath itself, multi-file torrents to downloadPath/<name>/<path...>.
+func NewLayout(info *torrent.InfoData, downloadPath string) (*Layout, error) {
+	if info.IsMultiFile() {
+		if err := checkPathSegment(info.Name); err != nil {
+			return nil, err
+		}
+		return NewLayoutAt(info, filepath.Join(downloadPath, info.Name))
+	}
+	return NewLayoutAt(info, downloadPath)
+}
+
+// NewLayoutAt is like NewLayout but contentPath is the file of a single-file
+// torrent or the top directory of a multi-file one, whatever its name.
+func NewLayoutAt(info *torrent.InfoData, contentPath string) (*Layout, error) {
+	layout := &Layout{PieceLength: info.Piece_length, info: info}
+	if !info.IsMultiFile() {
+		layout.Files = []File{{Path: contentPath, TorrentPath: []string{info.Name}, Length: info.Length}}
+		layout.TotalLength = info.Length
+		return layout, nil
+	}
+
+	root := contentPath
+	offset := 0
+	for _, file := range info.Files {
+		if len(file.Path) == 0 {
+			return nil, fmt.Errorf("file entry with empty path in torrent %s", info.Name)
+		}
+		for _, segment := range file.Path {
+			if err := checkPathSegment(segment); err != nil {
+				return nil, err
+			}
+		}
+		path := filepath.Join(append([]string{root}, file.Path...)...)
+		layout.Files = append(layout.Files, File{
+			Path:        path,
+			TorrentPath: append([]string{info.Name}, file.Path...),
+			Length:      file.Length,
+			Offset:      offset,
+			Pad:         file.IsPad(),
+		})
+		offset += file.Length
+	}
+	layout.TotalLength = offset
+	return layout, nil
+}
+
+// checkPathSegment rejects names that would escape the download directory.
+func checkPathSegment(segment string) error {
+	if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, `/\`) {
+		return fmt.Errorf("invalid path segment %q in torrent", segment)
+	}
+	return nil
+}
+
+// Spans returns the file regions covered by the piece at pieceIndex.
+func (l *Layout) Spans(pieceIndex int) []Span {
+	start := pieceIndex * l.PieceLength
+	end := start + l.info.PieceSize(pieceIndex)
+	var spans []Span
+	for i := range l.Files {
+		file := &l.Files[i]
+		fileEnd := file.Offset + file.Length
+		if fileEnd <= start || file.Offset >= end {
+			continue
+		}
+		spanStart := max(start, file.Offset)
+		spanEnd := min(end, fileEnd)
+		spans = append(spans, Span{
+			File:        file,
+			FileOffset:  spanStart - file.Offset,
+			PieceOffset: spanStart - start,
+			Length:      spanEnd - spanStart,
+		})
+	}
+	return spans
+}
+
+// Create makes the directory tree and truncates every file to its final
+// size, so pieces can be written in any order.
+func (l *Layout) Create() error {
+	for _, file := range l.Files {
+		if file.Pad {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(file.Path), 0755); err != nil {
+			return fmt.Errorf("error creating directory for %s: %v", file.Path, err)
+		}
+		f, err := os.OpenFile(file.Path, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("error opening file %s: %v", file.Path, err)
+		}
+		err = f.Truncate(int64(file.Length))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error sizing file %s: %v", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// WritePiece writes a verified piece to every file it overlaps.
+func (l *Layout) WritePiece(pieceIndex int, pieceData []byte) error {
+	for _, span := range l.Spans(pieceIndex) {
+		if span.File.Pad {
+			continue
+		}
+		f, err := os.OpenFile(span.File.Path, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("error opening file %s: %v", span.File.Path, err)
+		}
+		_, err = f.WriteAt(pieceData[span.PieceOffset:span.PieceOffset+span.Length], int64(span.FileOffset))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error writing piece %d to %s: %v", pieceIndex, span.File.Path, err)
+		}
+	}
+	return nil
+}
+
+// ReadPiece reads the piece at pieceIndex back from disk.
+func (l *Layout) ReadPiece(pieceIndex int) ([]byte, error) {
+	pieceData := make([]byte, l.info.PieceSize(pieceIndex))
+	for _, span := range l.Spans(pieceIndex) {
+		// padding reads back as the zeroes it was hashed as
+		if span.File.Pad {
+			continue
+		}
+		f, err := os.Open(span.File.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening file %s: %v", span.File.Path, err)
+		}
+		_, err = f.ReadAt(pieceData[span.PieceOffset:span.PieceOffset+span.Length], int64(span.FileOffset))
+		f.Close()
+		if err == io.EOF {
+			return nil, fmt.Errorf("file %s is shorter than expected", span.File.Path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading piece %d from %s: %v", pieceIndex, span.File.Path, err)
+		}
+	}
+	return pieceData, nil
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+// multiFileInfo has files of 10, 5 and 20 bytes in pieces of 8, so pieces
+// cross file boundaries; the piece hashes are never checked here.
+func multiFileInfo() *torrent.InfoData {
+	return &torrent.InfoData{
+		Name:         "dir",
+		Piece_length: 8,
+		Pieces:       string(make([]byte, 5*20)),
+		Files: []torrent.FileInfo{
+			{Length: 10, Path: []string{"a"}},
+			{Length: 5, Path: []string{"sub", "b"}},
+			{Length: 20, Path: []string{"c"}},
+		},
+	}
+}
+
+type spanRef struct {
+	file                            int
+	fileOffset, pieceOffset, length int
+}
+
+func spanRefs(l *Layout, pieceIndex int) []spanRef {
+	var refs []spanRef
+	for _, span := range l.Spans(pieceIndex) {
+		refs = append(refs, spanRef{int(span.File.Offset), span.FileOffset, span.PieceOffset, span.Length})
+	}
+	return refs
+}
+
+func TestSpans(t *testing.T) {
+	root := t.TempDir()
+	layout, err := NewLayout(multiFileInfo(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.TotalLength != 35 || layout.Files[1].Path != filepath.Join(root, "dir", "sub", "b") {
+		t.Fatalf("layout %+v", layout)
+	}
+	if got := layout.Files[2].TorrentPath; !reflect.DeepEqual(got, []string{"dir", "c"}) {
+		t.Errorf("torrent path %v", got)
+	}
+
+	// spans are named by the offset of their file: a at 0, b at 10, c at 15
+	tests := []struct {
+		piece int
+		want  []spanRef
+	}{
+		{0, []spanRef{{0, 0, 0, 8}}},
+		{1, []spanRef{{0, 8, 0, 2}, {10, 0, 2, 5}, {15, 0, 7, 1}}},
+		{2, []spanRef{{15, 1, 0, 8}}},
+		// the last piece is 3 bytes
+		{4, []spanRef{{15, 17, 0, 3}}},
+	}
+	for _, tt := range tests {
+		if got := spanRefs(layout, tt.piece); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("piece %d spans %v, want %v", tt.piece, got, tt.want)
+		}
+	}
+}
+
+func TestWriteAndReadPieces(t *testing.T) {
+	root := t.TempDir()
+	info := multiFileInfo()
+	layout, err := NewLayout(info, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := layout.Create(); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxy")
+	// pieces can be written in any order
+	for _, i := range []int{3, 0, 4, 2, 1} {
+		if err := layout.WritePiece(i, content[i*8:min(i*8+8, len(content))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, want := range map[string]string{"a": "0123456789", "sub/b": "abcde", "c": "fghijklmnopqrstuvwxy"} {
+		got, err := os.ReadFile(filepath.Join(root, "dir", path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s holds %q, want %q", path, got, want)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		got, err := layout.ReadPiece(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := content[i*8 : min(i*8+8, len(content))]; !bytes.Equal(got, want) {
+			t.Errorf("piece %d read back as %q, want %q", i, got, want)
+		}
+	}
+
+	// a file cut short on disk can't be read back
+	if err := os.Truncate(filepath.Join(root, "dir", "c"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := layout.ReadPiece(2); err == nil {
+		t.Fatal("piece read from a short file")
+	}
+}
+
+func TestPadFiles(t *testing.T) {
+	root := t.TempDir()
+	info := &torrent.InfoData{
+		Name:         "dir",
+		Piece_length: 8,
+		Pieces:       string(make([]byte, 2*20)),
+		Files: []torrent.FileInfo{
+			{Length: 5, Path: []string{"a"}},
+			{Length: 3, Path: []string{".pad", "3"}, Attr: "p"},
+			{Length: 8, Path: []string{"b"}},
+		},
+	}
+	layout, err := NewLayout(info, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := layout.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := layout.WritePiece(0, []byte("hello\x00\x00\x00")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "dir", ".pad")); !os.IsNotExist(err) {
+		t.Fatal("pad file created on disk")
+	}
+	got, err := layout.ReadPiece(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello\x00\x00\x00" {
+		t.Fatalf("piece with padding read back as %q", got)
+	}
+}
+
+func TestSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.bin")
+	info := &torrent.InfoData{Name: "ignored.bin", Length: 12, Piece_length: 8, Pieces: string(make([]byte, 2*20))}
+	layout, err := NewLayout(info, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layout.Files) != 1 || layout.Files[0].Path != path {
+		t.Fatalf("single-file layout %+v, want the download path itself", layout.Files)
+	}
+	if got := spanRefs(layout, 1); !reflect.DeepEqual(got, []spanRef{{0, 8, 0, 4}}) {
+		t.Fatalf("last piece spans %v", got)
+	}
+}
+
+func TestRejectsEscapingPaths(t *testing.T) {
+	for _, path := range [][]string{{".."}, {"sub", "..", "..", "x"}, {"a/b"}, {`a\\b`}, {""}, {"."}, {}} {
+		info := multiFileInfo()
+		info.Files[1].Path = path
+		if _, err := NewLayout(info, t.TempDir()); err == nil {
+			t.Errorf("path %q accepted", path)
+		}
+	}
+	info := multiFileInfo()
+	info.Name = ".."
+	if _, err := NewLayout(info, t.TempDir()); err == nil {
+		t.Error("torrent name .. accepted")
+	}
+}
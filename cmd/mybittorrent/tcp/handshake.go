@@ -0,0 +1,107 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peerid"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+const protocol = "BitTorrent protocol"
+
+// Reserved holds the 8 reserved bytes of the handshake as a big-endian
+// integer, so bit 0 is the last bit of the last byte.
+type Reserved uint64
+
+// Capabilities a peer advertises in the reserved bytes.
+const (
+	// DHT is BEP 5: the peer runs a DHT node and sends its port.
+	DHT Reserved = 1 << 0
+	// Fast is the Fast Extension of BEP 6.
+	Fast Reserved = 1 << 2
+	// ExtensionProtocol is BEP 10, which carries ut_metadata and ut_pex.
+	ExtensionProtocol Reserved = 1 << 20
+)
+
+// DefaultReserved is what this client supports on a peer connection.
+var DefaultReserved = ExtensionProtocol
+
+// Has reports whether every bit of c is set.
+func (r Reserved) Has(c Reserved) bool {
+	return r&c == c
+}
+
+// ErrInfoHashMismatch is returned when the peer answers the handshake for a
+// different torrent.
+var ErrInfoHashMismatch = errors.New("peer answered with a different info hash")
+
+// PeerInfo is what the peer told about itself in its handshake.
+type PeerInfo struct {
+	ID       [20]byte
+	Reserved Reserved
+}
+
+// HexID returns the peer ID as hex, the way the handshake command prints it.
+func (p PeerInfo) HexID() string {
+	return hex.EncodeToString(p.ID[:])
+}
+
+func (p PeerInfo) SupportsExtensions() bool {
+	return p.Reserved.Has(ExtensionProtocol)
+}
+
+func (p PeerInfo) SupportsDHT() bool {
+	return p.Reserved.Has(DHT)
+}
+
+func (p PeerInfo) SupportsFast() bool {
+	return p.Reserved.Has(Fast)
+}
+
+// CompleteHandshake sends our handshake for infoHash advertising reserved,
+// then reads the peer's and checks that it speaks the same protocol about
+// the same torrent.
+func CompleteHandshake(conn net.Conn, infoHash [20]byte, reserved Reserved) (PeerInfo, error) {
+	tcpRequest := torrent.TCPRequest{Length: byte(len(protocol)), InfoHash: infoHash, PeerID: peerid.ID()}
+	copy(tcpRequest.Protocol[:], protocol)
+	binary.BigEndian.PutUint64(tcpRequest.Reserve[:], uint64(reserved))
+
+	var tcpBuf []byte
+	tcpBuf = append(tcpBuf, tcpRequest.Length)
+	tcpBuf = append(tcpBuf, tcpRequest.Protocol[:]...)
+	tcpBuf = append(tcpBuf, tcpRequest.Reserve[:]...)
+	tcpBuf = append(tcpBuf, tcpRequest.InfoHash[:]...)
+	tcpBuf = append(tcpBuf, tcpRequest.PeerID[:]...)
+	if _, err := conn.Write(tcpBuf); err != nil {
+		return PeerInfo{}, err
+	}
+
+	peerBuf := make([]byte, len(tcpBuf))
+	if _, err := io.ReadFull(conn, peerBuf[:1]); err != nil {
+		return PeerInfo{}, err
+	}
+	if int(peerBuf[0]) != len(protocol) {
+		return PeerInfo{}, fmt.Errorf("unexpected protocol name length %d", peerBuf[0])
+	}
+	if _, err := io.ReadFull(conn, peerBuf[1:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return PeerInfo{}, err
+	}
+	if string(peerBuf[1:20]) != protocol {
+		return PeerInfo{}, fmt.Errorf("unexpected protocol %q", peerBuf[1:20])
+	}
+	if [20]byte(peerBuf[28:48]) != infoHash {
+		return PeerInfo{}, ErrInfoHashMismatch
+	}
+	return PeerInfo{
+		ID:       [20]byte(peerBuf[48:68]),
+		Reserved: Reserved(binary.BigEndian.Uint64(peerBuf[20:28])),
+	}, nil
+}
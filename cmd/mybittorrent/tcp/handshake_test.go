@@ -0,0 +1,116 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peerid"
+)
+
+var (
+	testInfoHash = [20]byte{0xde, 0xad}
+	testPeerID   = [20]byte{'-', 'X', 'X', '0', '0', '0', '1', '-'}
+)
+
+func handshakeBytes(name string, reserved Reserved, infoHash [20]byte) []byte {
+	b := append([]byte{byte(len(name))}, name...)
+	b = binary.BigEndian.AppendUint64(b, uint64(reserved))
+	b = append(b, infoHash[:]...)
+	return append(b, testPeerID[:]...)
+}
+
+// handshakeWith runs CompleteHandshake against a peer that answers with
+// reply and then closes. It returns what the client sent as well.
+func handshakeWith(t *testing.T, reserved Reserved, reply []byte) (PeerInfo, []byte, error) {
+	t.Helper()
+	client, peer := net.Pipe()
+	defer client.Close()
+	sent := make(chan []byte, 1)
+	go func() {
+		defer peer.Close()
+		buf := make([]byte, 68)
+		io.ReadFull(peer, buf)
+		sent <- buf
+		peer.Write(reply)
+	}()
+	info, err := CompleteHandshake(client, testInfoHash, reserved)
+	return info, <-sent, err
+}
+
+func TestHandshake(t *testing.T) {
+	info, sent, err := handshakeWith(t, ExtensionProtocol|DHT, handshakeBytes(protocol, DefaultReserved, testInfoHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != testPeerID {
+		t.Errorf("peer ID %x, want %x", info.ID, testPeerID)
+	}
+	id := peerid.ID()
+	want := append(handshakeBytes(protocol, ExtensionProtocol|DHT, testInfoHash)[:48], id[:]...)
+	if !bytes.Equal(sent, want) {
+		t.Fatalf("sent handshake\n%x\nwant\n%x", sent, want)
+	}
+	// reserved byte 5 bit 0x10 and byte 7 bit 0x01, as the BEPs put them
+	if sent[1+len(protocol)+5] != 0x10 || sent[1+len(protocol)+7] != 0x01 {
+		t.Fatalf("reserved bytes %x", sent[20:28])
+	}
+}
+
+func TestHandshakeReservedBits(t *testing.T) {
+	tests := []struct {
+		reserved                 Reserved
+		extensions, dht, fastExt bool
+	}{
+		{0, false, false, false},
+		{ExtensionProtocol, true, false, false},
+		{DHT, false, true, false},
+		{Fast, false, false, true},
+		{ExtensionProtocol | DHT | Fast, true, true, true},
+		// bits we know nothing about don't count as any of them
+		{1<<63 | 1<<1, false, false, false},
+	}
+	for _, tt := range tests {
+		info, _, err := handshakeWith(t, DefaultReserved, handshakeBytes(protocol, tt.reserved, testInfoHash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Reserved != tt.reserved {
+			t.Errorf("reserved %x parsed as %x", uint64(tt.reserved), uint64(info.Reserved))
+		}
+		if info.SupportsExtensions() != tt.extensions || info.SupportsDHT() != tt.dht || info.SupportsFast() != tt.fastExt {
+			t.Errorf("reserved %x: extensions %v, DHT %v, Fast %v", uint64(tt.reserved), info.SupportsExtensions(), info.SupportsDHT(), info.SupportsFast())
+		}
+	}
+}
+
+func TestHandshakeWrongProtocol(t *testing.T) {
+	for _, name := range []string{"BitTorrent protocoX", "BitTorrent", "bittorrent protocol"} {
+		if _, _, err := handshakeWith(t, DefaultReserved, handshakeBytes(name, 0, testInfoHash)); err == nil {
+			t.Errorf("handshake with protocol %q accepted", name)
+		}
+	}
+}
+
+func TestHandshakeInfoHashMismatch(t *testing.T) {
+	_, _, err := handshakeWith(t, DefaultReserved, handshakeBytes(protocol, 0, [20]byte{0xbe, 0xef}))
+	if !errors.Is(err, ErrInfoHashMismatch) {
+		t.Fatalf("handshake for another torrent = %v, want ErrInfoHashMismatch", err)
+	}
+}
+
+func TestHandshakeShortRead(t *testing.T) {
+	reply := handshakeBytes(protocol, 0, testInfoHash)
+	for _, n := range []int{1, 20, 47, 67} {
+		_, _, err := handshakeWith(t, DefaultReserved, reply[:n])
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("handshake cut after %d bytes = %v, want io.ErrUnexpectedEOF", n, err)
+		}
+	}
+	if _, _, err := handshakeWith(t, DefaultReserved, nil); err != io.EOF {
+		t.Errorf("peer hanging up before answering = %v, want io.EOF", err)
+	}
+}
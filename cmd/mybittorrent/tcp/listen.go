@@ -0,0 +1,17 @@
+package tcp
+
+import (
+	"net"
+	"strconv"
+)
+
+// Listen accepts peer connections on port over both IPv4 and IPv6. The
+// unspecified address gives a dual-stack socket where the system has one,
+// and whichever stack exists on single-stack hosts.
+func Listen(port int) (*net.TCPListener, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	return listener.(*net.TCPListener), nil
+}
@@ -1,20 +1,121 @@
 package torrent
 
+import (
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+)
+
+type FileInfo struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+	// Attr holds BEP 47 file attributes; "p" marks a padding file.
+	Attr string `bencode:"attr,omitempty"`
+}
 type InfoData struct {
-	Length       int    `bencode:"length"`
-	Name         string `bencode:"name"`
-	Piece_length int    `bencode:"piece length"`
-	Pieces       string `bencode:"pieces"`
+	Files        []FileInfo `bencode:"files,omitempty"`
+	Length       int        `bencode:"length,omitempty"`
+	Name         string     `bencode:"name"`
+	Piece_length int        `bencode:"piece length"`
+	Pieces       string     `bencode:"pieces"`
+	Private      int        `bencode:"private,omitempty"`
+	MetaVersion  int        `bencode:"meta version,omitempty"`
+	// FileTree and PieceLayers are filled in for v2 torrents when the file
+	// is loaded, they are not encoded back into the info dictionary.
+	FileTree    []V2File          `bencode:"-"`
+	PieceLayers map[string]string `bencode:"-"`
 }
 type Torrent struct {
-	Announce string   `bencode:"announce"`
-	Info     InfoData `bencode:"info"`
+	Announce     string            `bencode:"announce,omitempty"`
+	AnnounceList [][]string        `bencode:"announce-list,omitempty"`
+	Comment      string            `bencode:"comment,omitempty"`
+	CreatedBy    string            `bencode:"created by,omitempty"`
+	CreationDate int64             `bencode:"creation date,omitempty"`
+	Info         InfoData          `bencode:"info"`
+	URLList      URLList           `bencode:"url-list,omitempty"`
+	PieceLayers  map[string]string `bencode:"piece layers,omitempty"`
+	// InfoBytes is the info dictionary exactly as it appeared in the file;
+	// the info hash is computed over these bytes, Info is only a typed view.
+	InfoBytes []byte `bencode:"-"`
 }
 
+// URLList holds the BEP 19 web seeds. Torrents may give url-list as a single
+// string instead of a list, so both forms are accepted.
+type URLList []string
+
+func (u *URLList) UnmarshalBencode(data []byte) error {
+	var seed string
+	if err := bencode.Unmarshal(data, &seed); err == nil && seed != "" {
+		*u = URLList{seed}
+		return nil
+	}
+	var seeds []string
+	if err := bencode.Unmarshal(data, &seeds); err != nil {
+		return err
+	}
+	*u = seeds
+	return nil
+}
+
+// TrackerResponse is the body of an HTTP tracker announce response. A
+// response with FailureReason set carries nothing else.
 type TrackerResponse struct {
-	Interval int    `bencode:"interval"`
-	Peers    string `bencode:"peers"`
+	FailureReason  string       `bencode:"failure reason,omitempty"`
+	WarningMessage string       `bencode:"warning message,omitempty"`
+	Interval       int          `bencode:"interval"`
+	MinInterval    int          `bencode:"min interval,omitempty"`
+	TrackerID      string       `bencode:"tracker id,omitempty"`
+	Complete       int          `bencode:"complete,omitempty"`
+	Incomplete     int          `bencode:"incomplete,omitempty"`
+	Peers          TrackerPeers `bencode:"peers"`
+	// Peers6 are BEP 7 compact IPv6 peers, 18 bytes each.
+	Peers6 string `bencode:"peers6,omitempty"`
+}
+
+// TrackerPeer is one entry of the original, non-compact peer list.
+type TrackerPeer struct {
+	PeerID string `bencode:"peer id,omitempty"`
+	IP     string `bencode:"ip"`
+	Port   int    `bencode:"port"`
 }
+
+// TrackerPeers holds peers in whichever model the tracker used: the compact
+// string of BEP 23 or a list of dictionaries.
+type TrackerPeers struct {
+	Compact string
+	List    []TrackerPeer
+}
+
+func (p *TrackerPeers) UnmarshalBencode(data []byte) error {
+	if len(data) > 0 && data[0] == 'l' {
+		p.Compact = ""
+		return bencode.Unmarshal(data, &p.List)
+	}
+	p.List = nil
+	return bencode.Unmarshal(data, &p.Compact)
+}
+
+func (p TrackerPeers) MarshalBencode() ([]byte, error) {
+	if p.List != nil {
+		return bencode.Marshal(p.List)
+	}
+	return bencode.Marshal(p.Compact)
+}
+
+// ScrapeResponse is the body of an HTTP tracker scrape response, keyed by
+// raw info hash.
+type ScrapeResponse struct {
+	FailureReason string                `bencode:"failure reason,omitempty"`
+	Files         map[string]ScrapeFile `bencode:"files"`
+}
+
+type ScrapeFile struct {
+	Complete   int    `bencode:"complete"`
+	Downloaded int    `bencode:"downloaded"`
+	Incomplete int    `bencode:"incomplete"`
+	Name       string `bencode:"name,omitempty"`
+}
+
 type TCPRequest struct {
 	Length   uint8
 	Protocol [19]byte
@@ -22,3 +123,71 @@ type TCPRequest struct {
 	InfoHash [20]byte
 	PeerID   [20]byte
 }
+
+// Trackers returns the tracker tiers of the torrent. Per BEP 12 announce-list
+// takes precedence over announce when it is present.
+func (t *Torrent) Trackers() [][]string {
+	var tiers [][]string
+	for _, tier := range t.AnnounceList {
+		if len(tier) > 0 {
+			tiers = append(tiers, append([]string(nil), tier...))
+		}
+	}
+	if len(tiers) == 0 && t.Announce != "" {
+		tiers = [][]string{{t.Announce}}
+	}
+	return tiers
+}
+
+// IsPad reports whether the file only exists to align the next file to a
+// piece boundary; its content is all zeroes and never written to disk.
+func (file *FileInfo) IsPad() bool {
+	return strings.Contains(file.Attr, "p")
+}
+
+// IsPrivate reports whether the torrent sets the BEP 27 private flag, which
+// restricts it to peers handed out by its own trackers.
+func (info *InfoData) IsPrivate() bool {
+	return info.Private == 1
+}
+
+// IsMultiFile reports whether the info dictionary describes a directory of
+// files rather than a single file.
+func (info *InfoData) IsMultiFile() bool {
+	return len(info.Files) > 0
+}
+
+// TotalLength is the number of bytes covered by the pieces, summed over all
+// files for multi-file torrents.
+func (info *InfoData) TotalLength() int {
+	if !info.IsMultiFile() {
+		return info.Length
+	}
+	total := 0
+	for _, file := range info.Files {
+		total += file.Length
+	}
+	return total
+}
+
+// PieceCount returns the number of pieces listed in the info dictionary. A
+// v2-only torrent has no v1 piece list, so it is derived from the
+// piece-aligned file layout instead.
+func (info *InfoData) PieceCount() int {
+	if info.Pieces == "" && info.Piece_length > 0 {
+		return (info.TotalLength() + info.Piece_length - 1) / info.Piece_length
+	}
+	return len(info.Pieces) / 20
+}
+
+// PieceSize returns the length of the piece at pieceIndex; only the last
+// piece can be shorter than Piece_length.
+func (info *InfoData) PieceSize(pieceIndex int) int {
+	if pieceIndex == info.PieceCount()-1 {
+		lastPieceLength := info.TotalLength() % info.Piece_length
+		if lastPieceLength > 0 {
+			return lastPieceLength
+		}
+	}
+	return info.Piece_length
+}
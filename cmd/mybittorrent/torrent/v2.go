@@ -0,0 +1,117 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/merkle"
+)
+
+// V2File is a leaf of the BEP 52 file tree. FirstPiece is the index of the
+// file's first piece; in v2 every file starts on a piece boundary.
+type V2File struct {
+	Path       []string
+	Length     int
+	PiecesRoot string
+	FirstPiece int
+}
+
+// IsV1 reports whether the torrent carries a v1 SHA-1 piece list.
+func (info *InfoData) IsV1() bool {
+	return info.Pieces != ""
+}
+
+// IsV2 reports whether the torrent is a BEP 52 v2 or hybrid torrent.
+func (info *InfoData) IsV2() bool {
+	return info.MetaVersion == 2
+}
+
+// IsHybrid reports whether the torrent can be used in both v1 and v2 swarms.
+func (info *InfoData) IsHybrid() bool {
+	return info.IsV1() && info.IsV2()
+}
+
+// V1PieceHash returns the SHA-1 hash listed for pieceIndex.
+func (info *InfoData) V1PieceHash(pieceIndex int) []byte {
+	return []byte(info.Pieces[pieceIndex*20 : (pieceIndex+1)*20])
+}
+
+// VerifyPiece checks pieceData against every hash the torrent has for it:
+// the v1 SHA-1 and, for v2 and hybrid torrents, the SHA-256 merkle tree.
+func (info *InfoData) VerifyPiece(pieceIndex int, pieceData []byte) bool {
+	if pieceIndex < 0 || pieceIndex >= info.PieceCount() {
+		return false
+	}
+	if info.IsV1() {
+		hash := sha1.Sum(pieceData)
+		if !bytes.Equal(hash[:], info.V1PieceHash(pieceIndex)) {
+			return false
+		}
+	}
+	if info.IsV2() && len(info.FileTree) > 0 {
+		return info.verifyPieceV2(pieceIndex, pieceData)
+	}
+	return info.IsV1()
+}
+
+func (info *InfoData) verifyPieceV2(pieceIndex int, pieceData []byte) bool {
+	file := info.v2FileForPiece(pieceIndex)
+	if file == nil {
+		return false
+	}
+	// a hybrid piece may end in padding that isn't part of the v2 file
+	offset := (pieceIndex - file.FirstPiece) * info.Piece_length
+	dataLength := min(file.Length-offset, len(pieceData))
+	pieceData = pieceData[:dataLength]
+
+	if file.Length <= info.Piece_length {
+		blocks := merkle.BlockHashes(pieceData)
+		root := merkle.Root(blocks, merkle.NextPowerOfTwo(len(blocks)))
+		return string(root[:]) == file.PiecesRoot
+	}
+	layer, ok := info.PieceLayers[file.PiecesRoot]
+	layerIndex := pieceIndex - file.FirstPiece
+	if !ok || len(layer) < (layerIndex+1)*32 {
+		return false
+	}
+	hash := merkle.PieceHash(pieceData, info.Piece_length)
+	return string(hash[:]) == layer[layerIndex*32:(layerIndex+1)*32]
+}
+
+func (info *InfoData) v2FileForPiece(pieceIndex int) *V2File {
+	for i := range info.FileTree {
+		file := &info.FileTree[i]
+		if file.Length == 0 {
+			continue
+		}
+		pieces := (file.Length + info.Piece_length - 1) / info.Piece_length
+		if pieceIndex >= file.FirstPiece && pieceIndex < file.FirstPiece+pieces {
+			return file
+		}
+	}
+	return nil
+}
+
+// ValidPieceLayers checks that every piece layer hashes up to its file's
+// pieces root, so individual pieces can be trusted against the layer.
+func (info *InfoData) ValidPieceLayers() bool {
+	for _, file := range info.FileTree {
+		if file.Length <= info.Piece_length {
+			continue
+		}
+		layer, ok := info.PieceLayers[file.PiecesRoot]
+		pieces := (file.Length + info.Piece_length - 1) / info.Piece_length
+		if !ok || len(layer) != pieces*32 {
+			return false
+		}
+		hashes := make([][32]byte, pieces)
+		for i := range hashes {
+			copy(hashes[i][:], layer[i*32:])
+		}
+		root := merkle.LayerRoot(hashes, info.Piece_length)
+		if string(root[:]) != file.PiecesRoot {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+package tracker
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Peer is one client in a swarm, as it last announced itself. A client
+// that sent ipv6= (BEP 7) is reachable on both addresses.
+type Peer struct {
+	ID   string    `json:"id"`
+	IP   net.IP    `json:"ip,omitempty"`
+	IPv6 net.IP    `json:"ipv6,omitempty"`
+	Port int       `json:"port"`
+	Left int64     `json:"left"`
+	Seen time.Time `json:"seen"`
+}
+
+func (p *Peer) seeding() bool {
+	return p.Left == 0
+}
+
+type swarm struct {
+	// Peers are keyed by peer id.
+	Peers      map[string]*Peer `json:"peers"`
+	Downloaded int              `json:"downloaded"`
+}
+
+// Stats are a swarm's counts as reported in announce and scrape responses.
+type Stats struct {
+	Complete   int
+	Incomplete int
+	Downloaded int
+}
+
+// Registry holds the swarms a tracker knows about, in memory.
+type Registry struct {
+	mu     sync.Mutex
+	swarms map[[20]byte]*swarm
+	// allowed is the whitelist of info hashes; nil allows any.
+	allowed map[[20]byte]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{swarms: make(map[[20]byte]*swarm)}
+}
+
+// Allow restricts the registry to the given info hashes. Swarms of other
+// hashes are dropped.
+func (r *Registry) Allow(infoHashes [][20]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.allowed = make(map[[20]byte]bool)
+	for _, infoHash := range infoHashes {
+		r.allowed[infoHash] = true
+	}
+	for infoHash := range r.swarms {
+		if !r.allowed[infoHash] {
+			delete(r.swarms, infoHash)
+		}
+	}
+}
+
+func (r *Registry) Allowed(infoHash [20]byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.allowed == nil || r.allowed[infoHash]
+}
+
+// Announce records peer in the swarm of infoHash. Stopped removes it and
+// completed counts a finished download.
+func (r *Registry) Announce(infoHash [20]byte, peer Peer, event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.swarms[infoHash]
+	if s == nil {
+		if event == "stopped" {
+			return
+		}
+		s = &swarm{Peers: make(map[string]*Peer)}
+		r.swarms[infoHash] = s
+	}
+	if event == "stopped" {
+		delete(s.Peers, peer.ID)
+		return
+	}
+	if event == "completed" {
+		s.Downloaded++
+	}
+	s.Peers[peer.ID] = &peer
+}
+
+// Peers returns up to numWant random peers of the swarm, leaving out the
+// peer with id exclude.
+func (r *Registry) Peers(infoHash [20]byte, numWant int, exclude string) []Peer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.swarms[infoHash]
+	if s == nil {
+		return nil
+	}
+	peers := make([]Peer, 0, len(s.Peers))
+	for id, peer := range s.Peers {
+		if id != exclude {
+			peers = append(peers, *peer)
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	if len(peers) > numWant {
+		peers = peers[:numWant]
+	}
+	return peers
+}
+
+func (r *Registry) Stats(infoHash [20]byte) Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats(infoHash)
+}
+
+func (r *Registry) stats(infoHash [20]byte) Stats {
+	s := r.swarms[infoHash]
+	if s == nil {
+		return Stats{}
+	}
+	stats := Stats{Downloaded: s.Downloaded}
+	for _, peer := range s.Peers {
+		if peer.seeding() {
+			stats.Complete++
+		} else {
+			stats.Incomplete++
+		}
+	}
+	return stats
+}
+
+// All returns the stats of every swarm, for a scrape without info hashes.
+func (r *Registry) All() map[[20]byte]Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	all := make(map[[20]byte]Stats, len(r.swarms))
+	for infoHash := range r.swarms {
+		all[infoHash] = r.stats(infoHash)
+	}
+	return all
+}
+
+// Expire drops peers that haven't announced since before. Swarms left
+// empty are kept for their download count.
+func (r *Registry) Expire(before time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.swarms {
+		for id, peer := range s.Peers {
+			if peer.Seen.Before(before) {
+				delete(s.Peers, id)
+			}
+		}
+	}
+}
+
+// Save writes the swarms to path as JSON keyed by hex info hash.
+func (r *Registry) Save(path string) error {
+	r.mu.Lock()
+	state := make(map[string]*swarm, len(r.swarms))
+	for infoHash, s := range r.swarms {
+		state[hex.EncodeToString(infoHash[:])] = s
+	}
+	data, err := json.Marshal(state)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// write then rename, so a crash never leaves half a state file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads swarms saved by Save. A missing file is not an error.
+func (r *Registry) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading state file: %v", err)
+	}
+	state := make(map[string]*swarm)
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("error parsing state file %s: %v", path, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, s := range state {
+		var infoHash [20]byte
+		if len(key) != 40 {
+			return fmt.Errorf("invalid info hash %q in state file", key)
+		}
+		if _, err := hex.Decode(infoHash[:], []byte(key)); err != nil {
+			return fmt.Errorf("invalid info hash %q in state file", key)
+		}
+		if r.allowed != nil && !r.allowed[infoHash] {
+			continue
+		}
+		if s.Peers == nil {
+			s.Peers = make(map[string]*Peer)
+		}
+		r.swarms[infoHash] = s
+	}
+	return nil
+}
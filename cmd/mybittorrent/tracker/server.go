@@ -0,0 +1,325 @@
+package tracker
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/tcp"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+const (
+	defaultNumWant = 50
+	maxNumWant     = 200
+)
+
+// Server answers /announce and /scrape from a Registry. It is an
+// http.Handler, so tests can run it under httptest.
+type Server struct {
+	Registry *Registry
+	// Interval is how often clients are asked to announce; MinInterval
+	// is the least time they must wait between announces.
+	Interval    time.Duration
+	MinInterval time.Duration
+}
+
+func NewServer(registry *Registry) *Server {
+	return &Server{Registry: registry, Interval: 30 * time.Minute, MinInterval: time.Minute}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/announce":
+		s.announce(w, r)
+	case "/scrape":
+		s.scrape(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fail answers with a failure reason. Like most trackers it uses status
+// 200, since clients only look at the body.
+func fail(w http.ResponseWriter, reason string) {
+	writeBencode(w, struct {
+		FailureReason string `bencode:"failure reason"`
+	}{reason})
+}
+
+func writeBencode(w http.ResponseWriter, v interface{}) {
+	out, err := bencode.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(out)
+}
+
+func (s *Server) announce(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	infoHash, ok := infoHashParam(params.Get("info_hash"))
+	if !ok {
+		fail(w, "invalid info_hash")
+		return
+	}
+	if !s.Registry.Allowed(infoHash) {
+		fail(w, "torrent not registered with this tracker")
+		return
+	}
+	peerID := params.Get("peer_id")
+	if len(peerID) != 20 {
+		fail(w, "invalid peer_id")
+		return
+	}
+	port, err := strconv.Atoi(params.Get("port"))
+	if err != nil || port <= 0 || port > 65535 {
+		fail(w, "invalid port")
+		return
+	}
+	left, err := strconv.ParseInt(params.Get("left"), 10, 64)
+	if err != nil || left < 0 {
+		fail(w, "invalid left")
+		return
+	}
+	event := params.Get("event")
+	switch event {
+	case "", "started", "completed", "stopped":
+	default:
+		fail(w, "invalid event")
+		return
+	}
+	numWant := defaultNumWant
+	if n, err := strconv.Atoi(params.Get("numwant")); err == nil && n >= 0 {
+		numWant = min(n, maxNumWant)
+	}
+
+	// the address the request came from is used rather than the ip
+	// parameter, which would let anyone register someone else
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		fail(w, "unknown peer address")
+		return
+	}
+	peer := Peer{ID: peerID, Port: port, Left: left, Seen: time.Now()}
+	setAddress(&peer, net.ParseIP(host))
+	// BEP 7: a dual-stack client names its other address
+	for _, param := range []string{"ipv4", "ipv6"} {
+		if ip := net.ParseIP(params.Get(param)); ip != nil {
+			setAddress(&peer, ip)
+		}
+	}
+	s.Registry.Announce(infoHash, peer, event)
+
+	stats := s.Registry.Stats(infoHash)
+	resp := torrent.TrackerResponse{
+		Interval:    int(s.Interval / time.Second),
+		MinInterval: int(s.MinInterval / time.Second),
+		Complete:    stats.Complete,
+		Incomplete:  stats.Incomplete,
+	}
+	var peers []Peer
+	if event != "stopped" {
+		peers = s.Registry.Peers(infoHash, numWant, peerID)
+	}
+	if params.Get("compact") == "0" {
+		resp.Peers.List = dictPeers(peers, params.Get("no_peer_id") == "1")
+	} else {
+		resp.Peers.Compact, resp.Peers6 = compactPeers(peers)
+	}
+	writeBencode(w, resp)
+}
+
+func setAddress(peer *Peer, ip net.IP) {
+	if ip == nil {
+		return
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		peer.IP = ip4
+	} else {
+		peer.IPv6 = ip
+	}
+}
+
+// compactPeers packs peers as BEP 23 IPv4 and BEP 7 IPv6 strings.
+func compactPeers(peers []Peer) (string, string) {
+	var v4, v6 []byte
+	for _, peer := range peers {
+		if peer.IP != nil {
+			v4 = append(v4, peer.IP.To4()...)
+			v4 = append(v4, byte(peer.Port>>8), byte(peer.Port))
+		}
+		if peer.IPv6 != nil {
+			v6 = append(v6, peer.IPv6.To16()...)
+			v6 = append(v6, byte(peer.Port>>8), byte(peer.Port))
+		}
+	}
+	return string(v4), string(v6)
+}
+
+// dictPeers lists peers in the original model, one entry per address.
+func dictPeers(peers []Peer, noPeerID bool) []torrent.TrackerPeer {
+	list := []torrent.TrackerPeer{}
+	for _, peer := range peers {
+		for _, ip := range []net.IP{peer.IP, peer.IPv6} {
+			if ip == nil {
+				continue
+			}
+			entry := torrent.TrackerPeer{IP: ip.String(), Port: peer.Port}
+			if !noPeerID {
+				entry.PeerID = peer.ID
+			}
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// scrape reports the requested swarms, or every swarm when no info_hash is
+// given. Unknown hashes are left out.
+func (s *Server) scrape(w http.ResponseWriter, r *http.Request) {
+	resp := torrent.ScrapeResponse{Files: make(map[string]torrent.ScrapeFile)}
+	add := func(infoHash [20]byte, stats Stats) {
+		resp.Files[string(infoHash[:])] = torrent.ScrapeFile{
+			Complete:   stats.Complete,
+			Downloaded: stats.Downloaded,
+			Incomplete: stats.Incomplete,
+		}
+	}
+
+	requested := r.URL.Query()["info_hash"]
+	if len(requested) == 0 {
+		for infoHash, stats := range s.Registry.All() {
+			add(infoHash, stats)
+		}
+		writeBencode(w, resp)
+		return
+	}
+	for _, param := range requested {
+		infoHash, ok := infoHashParam(param)
+		if !ok {
+			fail(w, "invalid info_hash")
+			return
+		}
+		if !s.Registry.Allowed(infoHash) {
+			continue
+		}
+		if stats := s.Registry.Stats(infoHash); stats != (Stats{}) {
+			add(infoHash, stats)
+		}
+	}
+	writeBencode(w, resp)
+}
+
+func infoHashParam(value string) ([20]byte, bool) {
+	var infoHash [20]byte
+	if len(value) != 20 {
+		return infoHash, false
+	}
+	copy(infoHash[:], value)
+	return infoHash, true
+}
+
+// readWhitelist reads hex info hashes, one per line. Blank lines and lines
+// starting with # are skipped.
+func readWhitelist(path string) ([][20]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening whitelist: %v", err)
+	}
+	defer file.Close()
+
+	var hashes [][20]byte
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var infoHash [20]byte
+		if len(text) != 40 {
+			return nil, fmt.Errorf("%s:%d: info hash must be 40 hex characters", path, line)
+		}
+		if _, err := hex.Decode(infoHash[:], []byte(text)); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		hashes = append(hashes, infoHash)
+	}
+	return hashes, scanner.Err()
+}
+
+func TrackerCommand(args []string) {
+	flags := flag.NewFlagSet("tracker", flag.ContinueOnError)
+	port := flags.Int("port", 6969, "port to listen on, over IPv4 and IPv6")
+	interval := flags.Duration("interval", 30*time.Minute, "announce interval given to clients")
+	whitelist := flags.String("whitelist", "", "file of hex info hashes to allow; any hash when empty")
+	statePath := flags.String("state", "", "file to keep swarms in across restarts")
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+
+	registry := NewRegistry()
+	if *whitelist != "" {
+		hashes, err := readWhitelist(*whitelist)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		registry.Allow(hashes)
+	}
+	if *statePath != "" {
+		if err := registry.Load(*statePath); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	server := NewServer(registry)
+	server.Interval = *interval
+	server.MinInterval = min(time.Minute, *interval)
+
+	listener, err := tcp.Listen(*port)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Tracker listening on", listener.Addr())
+
+	save := func() {
+		if *statePath == "" {
+			return
+		}
+		if err := registry.Save(*statePath); err != nil {
+			fmt.Println(err)
+		}
+	}
+	go func() {
+		// a peer that misses two announces in a row is gone
+		ticker := time.NewTicker(time.Minute)
+		for range ticker.C {
+			registry.Expire(time.Now().Add(-2 * *interval))
+			save()
+		}
+	}()
+	go func() {
+		interrupted := make(chan os.Signal, 1)
+		signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+		<-interrupted
+		save()
+		os.Exit(0)
+	}()
+
+	if err := http.Serve(listener, server); err != nil {
+		fmt.Println(err)
+	}
+}
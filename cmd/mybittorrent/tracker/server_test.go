@@ -0,0 +1,186 @@
+package tracker
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/peers"
+)
+
+func startServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts.URL + "/announce"
+}
+
+// announce sends a started announce as the client with the given id byte
+// and peer port.
+func announce(t *testing.T, announceURL string, infoHash [20]byte, id byte, port int, left int64) *peers.AnnounceResponse {
+	t.Helper()
+	resp, err := peers.Announce(announceURL, peers.AnnounceRequest{
+		InfoHash: infoHash,
+		PeerID:   [20]byte{id},
+		Port:     port,
+		Left:     left,
+		Event:    peers.EventStarted,
+		NumWant:  -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAnnounceCompactPeers(t *testing.T) {
+	server := NewServer(NewRegistry())
+	announceURL := startServer(t, server)
+	infoHash := [20]byte{1}
+
+	first := announce(t, announceURL, infoHash, 1, 6881, 0)
+	if len(first.Peers) != 0 {
+		t.Fatalf("first peer was given %v, want no one but itself", first.Peers)
+	}
+	if first.Interval != server.Interval || first.MinInterval != server.MinInterval {
+		t.Errorf("intervals %v/%v, want %v/%v", first.Interval, first.MinInterval, server.Interval, server.MinInterval)
+	}
+	second := announce(t, announceURL, infoHash, 2, 6882, 100)
+	if !slices.Equal(second.Peers, []string{"127.0.0.1:6881"}) {
+		t.Fatalf("second peer was given %v, want the first", second.Peers)
+	}
+	if second.Seeders != 1 || second.Leechers != 1 {
+		t.Errorf("counts %d seeders, %d leechers, want 1 and 1", second.Seeders, second.Leechers)
+	}
+}
+
+func TestAnnounceDictPeers(t *testing.T) {
+	server := NewServer(NewRegistry())
+	// a tracker that ignores compact=1, as old ones do
+	announceURL := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		params.Set("compact", "0")
+		r.URL.RawQuery = params.Encode()
+		server.ServeHTTP(w, r)
+	}))
+	infoHash := [20]byte{2}
+
+	announce(t, announceURL, infoHash, 1, 6881, 0)
+	resp := announce(t, announceURL, infoHash, 2, 6882, 0)
+	if !slices.Equal(resp.Peers, []string{"127.0.0.1:6881"}) {
+		t.Fatalf("dictionary peer list decoded as %v, want the first peer", resp.Peers)
+	}
+}
+
+func TestAnnounceIPv6Peers(t *testing.T) {
+	announceURL := startServer(t, NewServer(NewRegistry()))
+	infoHash := [20]byte{3}
+
+	// a dual-stack client names its IPv6 address with ipv6= (BEP 7)
+	_, err := peers.Announce(announceURL, peers.AnnounceRequest{
+		InfoHash: infoHash,
+		PeerID:   [20]byte{1},
+		Port:     6881,
+		NumWant:  -1,
+		IPv6:     net.ParseIP("2001:db8::1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := announce(t, announceURL, infoHash, 2, 6882, 0)
+	want := []string{"127.0.0.1:6881", "[2001:db8::1]:6881"}
+	if !slices.Equal(resp.Peers, want) {
+		t.Fatalf("peers %v, want %v from peers and peers6", resp.Peers, want)
+	}
+}
+
+func TestAnnounceWhitelist(t *testing.T) {
+	registry := NewRegistry()
+	allowed := [20]byte{4}
+	registry.Allow([][20]byte{allowed})
+	announceURL := startServer(t, NewServer(registry))
+
+	announce(t, announceURL, allowed, 1, 6881, 0)
+	_, err := peers.Announce(announceURL, peers.AnnounceRequest{InfoHash: [20]byte{5}, PeerID: [20]byte{1}, Port: 6881, NumWant: -1})
+	var trackerErr *peers.TrackerError
+	if !errors.As(err, &trackerErr) {
+		t.Fatalf("announce of an unlisted torrent = %v, want a TrackerError", err)
+	}
+	if trackerErr.Reason != "torrent not registered with this tracker" {
+		t.Errorf("failure reason %q", trackerErr.Reason)
+	}
+	if len(registry.All()) != 1 {
+		t.Error("unlisted torrent got a swarm")
+	}
+}
+
+func TestScrape(t *testing.T) {
+	announceURL := startServer(t, NewServer(NewRegistry()))
+	infoHash := [20]byte{6}
+	announce(t, announceURL, infoHash, 1, 6881, 0)
+	announce(t, announceURL, infoHash, 2, 6882, 10)
+	announce(t, announceURL, infoHash, 3, 6883, 10)
+
+	stats, err := peers.Scrape(announceURL, [][20]byte{infoHash, {7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []peers.ScrapeStats{{Seeders: 1, Leechers: 2, Found: true}, {}}
+	if !slices.Equal(stats, want) {
+		t.Fatalf("scrape = %+v, want %+v", stats, want)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	registry := NewRegistry()
+	announceURL := startServer(t, NewServer(registry))
+	infoHash := [20]byte{8}
+
+	announce(t, announceURL, infoHash, 1, 6881, 0)
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	announce(t, announceURL, infoHash, 2, 6882, 0)
+	registry.Expire(cutoff)
+
+	resp := announce(t, announceURL, infoHash, 3, 6883, 0)
+	if !slices.Equal(resp.Peers, []string{"127.0.0.1:6882"}) {
+		t.Fatalf("peers %v after expiry, want only the one that announced since", resp.Peers)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	registry := NewRegistry()
+	announceURL := startServer(t, NewServer(registry))
+	infoHash := [20]byte{9}
+	announce(t, announceURL, infoHash, 1, 6881, 0)
+	if _, err := peers.Announce(announceURL, peers.AnnounceRequest{InfoHash: infoHash, PeerID: [20]byte{2}, Port: 6882, Event: peers.EventCompleted, NumWant: -1}); err != nil {
+		t.Fatal(err)
+	}
+	statePath := filepath.Join(t.TempDir(), "swarms.json")
+	if err := registry.Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewRegistry()
+	if err := restarted.Load(statePath); err != nil {
+		t.Fatal(err)
+	}
+	announceURL = startServer(t, NewServer(restarted))
+	stats, err := peers.Scrape(announceURL, [][20]byte{infoHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (peers.ScrapeStats{Seeders: 2, Completed: 1, Found: true}); stats[0] != want {
+		t.Fatalf("scrape after reload = %+v, want %+v", stats[0], want)
+	}
+	resp := announce(t, announceURL, infoHash, 3, 6883, 10)
+	slices.Sort(resp.Peers)
+	if !slices.Equal(resp.Peers, []string{"127.0.0.1:6881", "127.0.0.1:6882"}) {
+		t.Fatalf("peers after reload %v, want both saved peers", resp.Peers)
+	}
+}
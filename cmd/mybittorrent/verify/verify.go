@@ -0,0 +1,166 @@
+package verify
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	infoCommand "github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/info"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+type PieceStatus string
+
+const (
+	PieceValid   PieceStatus = "valid"
+	PieceInvalid PieceStatus = "invalid"
+	// PieceMissing means the piece couldn't be read, usually because a file
+	// it spans doesn't exist or is too short.
+	PieceMissing PieceStatus = "missing"
+)
+
+type FileReport struct {
+	Path          string `json:"path"`
+	Length        int    `json:"length"`
+	FirstPiece    int    `json:"first_piece"`
+	LastPiece     int    `json:"last_piece"`
+	TotalPieces   int    `json:"total_pieces"`
+	ValidPieces   int    `json:"valid_pieces"`
+	InvalidPieces int    `json:"invalid_pieces"`
+	MissingPieces int    `json:"missing_pieces"`
+	Complete      bool   `json:"complete"`
+}
+
+type Report struct {
+	Pieces  []PieceStatus `json:"piece_statuses"`
+	Files   []FileReport  `json:"files"`
+	Total   int           `json:"pieces"`
+	Valid   int           `json:"valid"`
+	Invalid int           `json:"invalid"`
+	Missing int           `json:"missing"`
+	// Complete is true when every piece on disk matches its hash.
+	Complete bool `json:"complete"`
+}
+
+// CheckPieces reads every piece through layout and checks it against the
+// hashes in info.
+func CheckPieces(info *torrent.InfoData, layout *storage.Layout) []PieceStatus {
+	statuses := make([]PieceStatus, info.PieceCount())
+	for pieceIndex := range statuses {
+		pieceData, err := layout.ReadPiece(pieceIndex)
+		switch {
+		case err != nil:
+			statuses[pieceIndex] = PieceMissing
+		case info.VerifyPiece(pieceIndex, pieceData):
+			statuses[pieceIndex] = PieceValid
+		default:
+			statuses[pieceIndex] = PieceInvalid
+		}
+	}
+	return statuses
+}
+
+// Check verifies the data under layout and summarises it per piece and per file.
+func Check(info *torrent.InfoData, layout *storage.Layout) *Report {
+	report := &Report{Pieces: CheckPieces(info, layout), Total: info.PieceCount()}
+	for _, status := range report.Pieces {
+		switch status {
+		case PieceValid:
+			report.Valid++
+		case PieceInvalid:
+			report.Invalid++
+		case PieceMissing:
+			report.Missing++
+		}
+	}
+	report.Complete = report.Valid == report.Total
+
+	for _, file := range layout.Files {
+		if file.Pad {
+			continue
+		}
+		fileReport := FileReport{
+			Path:       strings.Join(file.TorrentPath, "/"),
+			Length:     file.Length,
+			FirstPiece: -1,
+			LastPiece:  -1,
+			Complete:   true,
+		}
+		if file.Length > 0 {
+			fileReport.FirstPiece = file.Offset / layout.PieceLength
+			fileReport.LastPiece = (file.Offset + file.Length - 1) / layout.PieceLength
+			for pieceIndex := fileReport.FirstPiece; pieceIndex <= fileReport.LastPiece; pieceIndex++ {
+				fileReport.TotalPieces++
+				switch report.Pieces[pieceIndex] {
+				case PieceValid:
+					fileReport.ValidPieces++
+				case PieceInvalid:
+					fileReport.InvalidPieces++
+				case PieceMissing:
+					fileReport.MissingPieces++
+				}
+			}
+			fileReport.Complete = fileReport.ValidPieces == fileReport.TotalPieces
+		}
+		report.Files = append(report.Files, fileReport)
+	}
+	return report
+}
+
+// VerifyCommand checks the data at a path against a torrent. For a
+// multi-file torrent the path is the torrent's top directory.
+func VerifyCommand(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print only the report as JSON, with the status of every piece")
+	flags.Usage = func() {
+		fmt.Println("Usage: verify [-json] <torrent file> <file or directory>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return
+	}
+	metadata, err := infoCommand.LoadTorrentFile(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	layout, err := storage.NewLayoutAt(&metadata.Info, filepath.Clean(flags.Arg(1)))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	report := Check(&metadata.Info, layout)
+	if *jsonOutput {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	for pieceIndex, status := range report.Pieces {
+		fmt.Printf("Piece %d: %s\n", pieceIndex, status)
+	}
+	for _, file := range report.Files {
+		state := "complete"
+		if !file.Complete {
+			state = "incomplete"
+		}
+		fmt.Printf("File %s: %s, %d/%d pieces valid\n", file.Path, state, file.ValidPieces, file.TotalPieces)
+	}
+	summary, err := json.Marshal(report)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(string(summary))
+}
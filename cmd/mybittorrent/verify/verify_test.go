@@ -0,0 +1,144 @@
+package verify
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+// content is laid out as a (10 bytes), sub/b (5) and c (20) in pieces of 8,
+// so piece 1 spans all three files.
+const content = "0123456789abcdefghijklmnopqrstuvwxy"
+
+// writeContent writes the test files under a new directory and returns it
+// with the torrent describing them.
+func writeContent(t *testing.T) (string, *torrent.InfoData) {
+	t.Helper()
+	info := &torrent.InfoData{
+		Name:         "dir",
+		Piece_length: 8,
+		Files: []torrent.FileInfo{
+			{Length: 10, Path: []string{"a"}},
+			{Length: 5, Path: []string{"sub", "b"}},
+			{Length: 20, Path: []string{"c"}},
+		},
+	}
+	for start := 0; start < len(content); start += 8 {
+		hash := sha1.Sum([]byte(content[start:min(start+8, len(content))]))
+		info.Pieces += string(hash[:])
+	}
+
+	dir := t.TempDir()
+	offset := 0
+	for _, file := range info.Files {
+		path := filepath.Join(append([]string{dir}, file.Path...)...)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content[offset:offset+file.Length]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		offset += file.Length
+	}
+	return dir, info
+}
+
+func check(t *testing.T, dir string, info *torrent.InfoData) *Report {
+	t.Helper()
+	layout, err := storage.NewLayoutAt(info, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Check(info, layout)
+}
+
+func TestCheckComplete(t *testing.T) {
+	dir, info := writeContent(t)
+	report := check(t, dir, info)
+	if !report.Complete || report.Total != 5 || report.Valid != 5 {
+		t.Fatalf("report %+v, want 5 valid pieces", report)
+	}
+	wantFiles := []FileReport{
+		{Path: "dir/a", Length: 10, FirstPiece: 0, LastPiece: 1, TotalPieces: 2, ValidPieces: 2, Complete: true},
+		{Path: "dir/sub/b", Length: 5, FirstPiece: 1, LastPiece: 1, TotalPieces: 1, ValidPieces: 1, Complete: true},
+		{Path: "dir/c", Length: 20, FirstPiece: 1, LastPiece: 4, TotalPieces: 4, ValidPieces: 4, Complete: true},
+	}
+	if !reflect.DeepEqual(report.Files, wantFiles) {
+		t.Fatalf("files %+v, want %+v", report.Files, wantFiles)
+	}
+}
+
+func TestCheckCorruptedPiece(t *testing.T) {
+	dir, info := writeContent(t)
+	// byte 5 of c is byte 20 of the torrent, in piece 2
+	f, err := os.OpenFile(filepath.Join(dir, "c"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("X"), 5); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	report := check(t, dir, info)
+	want := []PieceStatus{PieceValid, PieceValid, PieceInvalid, PieceValid, PieceValid}
+	if !reflect.DeepEqual(report.Pieces, want) {
+		t.Fatalf("pieces %v, want %v", report.Pieces, want)
+	}
+	if report.Complete || report.Invalid != 1 || report.Missing != 0 {
+		t.Fatalf("report %+v", report)
+	}
+	if !report.Files[0].Complete || !report.Files[1].Complete {
+		t.Error("files sharing only valid pieces reported incomplete")
+	}
+	if c := report.Files[2]; c.Complete || c.InvalidPieces != 1 || c.ValidPieces != 3 {
+		t.Errorf("corrupted file reported as %+v", c)
+	}
+}
+
+func TestCheckMissingFile(t *testing.T) {
+	dir, info := writeContent(t)
+	if err := os.Remove(filepath.Join(dir, "sub", "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	report := check(t, dir, info)
+	// only the piece spanning b is lost; its neighbours share no bytes with it
+	want := []PieceStatus{PieceValid, PieceMissing, PieceValid, PieceValid, PieceValid}
+	if !reflect.DeepEqual(report.Pieces, want) {
+		t.Fatalf("pieces %v, want %v", report.Pieces, want)
+	}
+	if report.Complete || report.Missing != 1 {
+		t.Fatalf("report %+v", report)
+	}
+	for i, file := range report.Files {
+		if file.Complete || file.MissingPieces != 1 {
+			t.Errorf("file %d reported as %+v, want piece 1 missing", i, file)
+		}
+	}
+}
+
+func TestCheckShortFile(t *testing.T) {
+	dir, info := writeContent(t)
+	// c keeps the 4 bytes that end piece 1 and begin piece 2
+	if err := os.Truncate(filepath.Join(dir, "c"), 4); err != nil {
+		t.Fatal(err)
+	}
+
+	report := check(t, dir, info)
+	want := []PieceStatus{PieceValid, PieceValid, PieceMissing, PieceMissing, PieceMissing}
+	if !reflect.DeepEqual(report.Pieces, want) {
+		t.Fatalf("pieces %v, want %v", report.Pieces, want)
+	}
+	if report.Complete || report.Valid != 2 || report.Missing != 3 {
+		t.Fatalf("report %+v", report)
+	}
+	if c := report.Files[2]; c.Complete || c.ValidPieces != 1 || c.MissingPieces != 3 {
+		t.Errorf("short file reported as %+v", c)
+	}
+}
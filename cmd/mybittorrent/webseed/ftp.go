@@ -0,0 +1,124 @@
+package webseed
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ftpTimeout = 30 * time.Second
+
+// fetchFTPRange reads len(buf) bytes at offset from an ftp:// URL using a
+// passive-mode binary transfer resumed with REST.
+func fetchFTPRange(fileURL string, offset int64, buf []byte) error {
+	parsed, err := url.Parse(fileURL)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", fileURL, err)
+	}
+	host := parsed.Host
+	if parsed.Port() == "" {
+		host = net.JoinHostPort(parsed.Hostname(), "21")
+	}
+	conn, err := net.DialTimeout("tcp", host, ftpTimeout)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %v", host, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ftpTimeout))
+	text := textproto.NewConn(conn)
+
+	if _, _, err := text.ReadResponse(220); err != nil {
+		return fmt.Errorf("ftp greeting from %s: %v", host, err)
+	}
+	user, password := "anonymous", "anonymous@"
+	if parsed.User != nil {
+		user = parsed.User.Username()
+		if p, ok := parsed.User.Password(); ok {
+			password = p
+		}
+	}
+	code, _, err := ftpCommand(text, "USER "+user)
+	if err != nil {
+		return err
+	}
+	if code == 331 {
+		if code, _, err = ftpCommand(text, "PASS "+password); err != nil {
+			return err
+		}
+	}
+	if code != 230 {
+		return fmt.Errorf("ftp login to %s rejected with %d", host, code)
+	}
+	if code, _, err = ftpCommand(text, "TYPE I"); err != nil || code != 200 {
+		return fmt.Errorf("ftp TYPE I on %s failed: %d %v", host, code, err)
+	}
+	code, message, err := ftpCommand(text, "PASV")
+	if err != nil || code != 227 {
+		return fmt.Errorf("ftp PASV on %s failed: %d %v", host, code, err)
+	}
+	dataAddr, err := parsePASV(message)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		if code, _, err = ftpCommand(text, "REST "+strconv.FormatInt(offset, 10)); err != nil || code != 350 {
+			return fmt.Errorf("ftp REST on %s failed: %d %v", host, code, err)
+		}
+	}
+
+	dataConn, err := net.DialTimeout("tcp", dataAddr, ftpTimeout)
+	if err != nil {
+		return fmt.Errorf("error opening ftp data connection to %s: %v", dataAddr, err)
+	}
+	defer dataConn.Close()
+	dataConn.SetDeadline(time.Now().Add(ftpTimeout))
+	if code, _, err = ftpCommand(text, "RETR "+parsed.Path); err != nil || (code != 150 && code != 125) {
+		return fmt.Errorf("ftp RETR %s failed: %d %v", parsed.Path, code, err)
+	}
+	if _, err := io.ReadFull(dataConn, buf); err != nil {
+		return fmt.Errorf("error reading %s: %v", fileURL, err)
+	}
+	// we stop reading mid-file, so the server's transfer status is irrelevant
+	return nil
+}
+
+func ftpCommand(text *textproto.Conn, command string) (int, string, error) {
+	if err := text.PrintfLine("%s", command); err != nil {
+		return 0, "", fmt.Errorf("error sending ftp command: %v", err)
+	}
+	code, message, err := text.ReadResponse(0)
+	if err != nil {
+		if _, ok := err.(*textproto.Error); !ok {
+			return 0, "", fmt.Errorf("error reading ftp response: %v", err)
+		}
+	}
+	return code, message, nil
+}
+
+// parsePASV extracts the data address from "Entering Passive Mode (h1,h2,h3,h4,p1,p2)".
+func parsePASV(message string) (string, error) {
+	start := strings.Index(message, "(")
+	end := strings.Index(message, ")")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("malformed PASV response %q", message)
+	}
+	parts := strings.Split(message[start+1:end], ",")
+	if len(parts) != 6 {
+		return "", fmt.Errorf("malformed PASV response %q", message)
+	}
+	numbers := make([]int, 6)
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 || n > 255 {
+			return "", fmt.Errorf("malformed PASV response %q", message)
+		}
+		numbers[i] = n
+	}
+	ip := fmt.Sprintf("%d.%d.%d.%d", numbers[0], numbers[1], numbers[2], numbers[3])
+	return net.JoinHostPort(ip, strconv.Itoa(numbers[4]*256+numbers[5])), nil
+}
@@ -0,0 +1,110 @@
+package webseed
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// FileURL returns where a web seed (BEP 19) serves one file of the torrent.
+// A single-file seed URL names the file itself unless it ends in a slash;
+// for multi-file torrents the URL is the directory holding the torrent name.
+func FileURL(seedURL string, info *torrent.InfoData, torrentPath []string) string {
+	if !info.IsMultiFile() && !strings.HasSuffix(seedURL, "/") {
+		return seedURL
+	}
+	if !strings.HasSuffix(seedURL, "/") {
+		seedURL += "/"
+	}
+	escaped := make([]string, len(torrentPath))
+	for i, segment := range torrentPath {
+		escaped[i] = url.PathEscape(segment)
+	}
+	return seedURL + strings.Join(escaped, "/")
+}
+
+// FetchPiece downloads the piece at pieceIndex from the web seed with one
+// ranged request per file the piece overlaps, and returns it only if it
+// passes the same hash check as pieces received from peers.
+func FetchPiece(seedURL string, info *torrent.InfoData, pieceIndex int) ([]byte, error) {
+	if pieceIndex < 0 || pieceIndex >= info.PieceCount() {
+		return nil, fmt.Errorf("piece index %d out of range", pieceIndex)
+	}
+	layout, err := storage.NewLayout(info, "")
+	if err != nil {
+		return nil, err
+	}
+	pieceData := make([]byte, info.PieceSize(pieceIndex))
+	for _, span := range layout.Spans(pieceIndex) {
+		if span.File.Pad {
+			continue
+		}
+		fileURL := FileURL(seedURL, info, span.File.TorrentPath)
+		buf := pieceData[span.PieceOffset : span.PieceOffset+span.Length]
+		if strings.HasPrefix(fileURL, "ftp://") {
+			err = fetchFTPRange(fileURL, int64(span.FileOffset), buf)
+		} else {
+			err = fetchHTTPRange(fileURL, int64(span.FileOffset), buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !info.VerifyPiece(pieceIndex, pieceData) {
+		return nil, fmt.Errorf("piece %d from web seed %s failed hash verification", pieceIndex, seedURL)
+	}
+	return pieceData, nil
+}
+
+func fetchHTTPRange(fileURL string, offset int64, buf []byte) error {
+	req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+	if err != nil {
+		return fmt.Errorf("error building request for %s: %v", fileURL, err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(len(buf))-1))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error fetching %s: %v", fileURL, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server ignored the range, skip ahead to the part we asked for
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			return fmt.Errorf("error reading %s: %v", fileURL, err)
+		}
+	default:
+		return fmt.Errorf("web seed %s answered %s", fileURL, resp.Status)
+	}
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return fmt.Errorf("error reading %s: %v", fileURL, err)
+	}
+	return nil
+}
+
+// Sources returns the HTTP and FTP web seeds of the torrent; other schemes
+// are skipped.
+func Sources(metadata *torrent.Torrent) []string {
+	var seeds []string
+	for _, seedURL := range metadata.URLList {
+		parsed, err := url.Parse(seedURL)
+		if err != nil {
+			continue
+		}
+		switch parsed.Scheme {
+		case "http", "https", "ftp":
+			seeds = append(seeds, seedURL)
+		}
+	}
+	return seeds
+}
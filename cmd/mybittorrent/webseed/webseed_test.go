@@ -0,0 +1,171 @@
+package webseed
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/cmd/mybittorrent/torrent"
+)
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func pieceHashes(content []byte, pieceLength int) string {
+	var pieces []byte
+	for i := 0; i < len(content); i += pieceLength {
+		sum := sha1.Sum(content[i:min(i+pieceLength, len(content))])
+		pieces = append(pieces, sum[:]...)
+	}
+	return string(pieces)
+}
+
+// fileServer serves files by URL path with Range support and records the
+// Range header of every request.
+type fileServer struct {
+	files map[string][]byte
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	content, ok := s.files[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.URL.Path+" "+r.Header.Get("Range"))
+	s.mu.Unlock()
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+func TestFileURL(t *testing.T) {
+	single := &torrent.InfoData{Name: "a b.iso", Length: 1}
+	multi := &torrent.InfoData{Name: "album", Files: []torrent.FileInfo{{Length: 1, Path: []string{"cd 1", "track#1.flac"}}}}
+	for _, tt := range []struct {
+		seedURL string
+		info    *torrent.InfoData
+		path    []string
+		want    string
+	}{
+		{"http://host/files/x.iso", single, []string{"a b.iso"}, "http://host/files/x.iso"},
+		{"http://host/files/", single, []string{"a b.iso"}, "http://host/files/a%20b.iso"},
+		{"http://host/files", multi, []string{"album", "cd 1", "track#1.flac"}, "http://host/files/album/cd%201/track%231.flac"},
+		{"http://host/files/", multi, []string{"album", "cd 1", "track#1.flac"}, "http://host/files/album/cd%201/track%231.flac"},
+	} {
+		if got := FileURL(tt.seedURL, tt.info, tt.path); got != tt.want {
+			t.Errorf("FileURL(%q, %v) = %q, want %q", tt.seedURL, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFetchPieceSingleFileRange(t *testing.T) {
+	const pieceLength = 32768
+	content := randomBytes(3*pieceLength + 1000)
+	info := &torrent.InfoData{Name: "x.bin", Length: len(content), Piece_length: pieceLength, Pieces: pieceHashes(content, pieceLength)}
+	server := &fileServer{files: map[string][]byte{"/x.bin": content}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	for pieceIndex := 0; pieceIndex < info.PieceCount(); pieceIndex++ {
+		got, err := FetchPiece(ts.URL+"/x.bin", info, pieceIndex)
+		if err != nil {
+			t.Fatalf("piece %d: %v", pieceIndex, err)
+		}
+		start := pieceIndex * pieceLength
+		if !bytes.Equal(got, content[start:min(start+pieceLength, len(content))]) {
+			t.Fatalf("piece %d: wrong data", pieceIndex)
+		}
+	}
+	want := fmt.Sprintf("/x.bin bytes=%d-%d", 3*pieceLength, len(content)-1)
+	if last := server.ranges[len(server.ranges)-1]; last != want {
+		t.Errorf("last request was %q, want %q", last, want)
+	}
+}
+
+func TestFetchPieceMultiFile(t *testing.T) {
+	const pieceLength = 16384
+	// the second piece starts in the first file and ends in the third
+	first, second, third := randomBytes(20000), randomBytes(3000), randomBytes(30000)
+	content := append(append(append([]byte(nil), first...), second...), third...)
+	info := &torrent.InfoData{
+		Name: "my album",
+		Files: []torrent.FileInfo{
+			{Length: len(first), Path: []string{"a.bin"}},
+			{Length: len(second), Path: []string{"sub dir", "b.bin"}},
+			{Length: len(third), Path: []string{"c.bin"}},
+		},
+		Piece_length: pieceLength,
+		Pieces:       pieceHashes(content, pieceLength),
+	}
+	server := &fileServer{files: map[string][]byte{
+		"/seed/my album/a.bin":         first,
+		"/seed/my album/sub dir/b.bin": second,
+		"/seed/my album/c.bin":         third,
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	got, err := FetchPiece(ts.URL+"/seed", info, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content[pieceLength:2*pieceLength]) {
+		t.Fatal("wrong data for piece 1")
+	}
+	want := []string{
+		fmt.Sprintf("/seed/my album/a.bin bytes=%d-%d", pieceLength, len(first)-1),
+		fmt.Sprintf("/seed/my album/sub dir/b.bin bytes=0-%d", len(second)-1),
+		fmt.Sprintf("/seed/my album/c.bin bytes=0-%d", 2*pieceLength-len(first)-len(second)-1),
+	}
+	if strings.Join(server.ranges, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests were\n%s\nwant\n%s", strings.Join(server.ranges, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestFetchPieceIgnoredRange(t *testing.T) {
+	const pieceLength = 16384
+	content := randomBytes(2 * pieceLength)
+	info := &torrent.InfoData{Name: "x.bin", Length: len(content), Piece_length: pieceLength, Pieces: pieceHashes(content, pieceLength)}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer ts.Close()
+
+	got, err := FetchPiece(ts.URL+"/x.bin", info, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content[pieceLength:]) {
+		t.Fatal("wrong data when the server ignores Range")
+	}
+}
+
+func TestFetchPieceRejectsBadData(t *testing.T) {
+	const pieceLength = 16384
+	content := randomBytes(pieceLength)
+	info := &torrent.InfoData{Name: "x.bin", Length: len(content), Piece_length: pieceLength, Pieces: pieceHashes(content, pieceLength)}
+	corrupt := append([]byte(nil), content...)
+	corrupt[100] ^= 0xff
+	ts := httptest.NewServer(&fileServer{files: map[string][]byte{"/x.bin": corrupt}})
+	defer ts.Close()
+
+	if _, err := FetchPiece(ts.URL+"/x.bin", info, 0); err == nil {
+		t.Fatal("corrupt piece was accepted")
+	}
+	if _, err := FetchPiece(ts.URL+"/missing.bin", info, 0); err == nil {
+		t.Fatal("404 was accepted")
+	}
+}
@@ -0,0 +1,306 @@
+// Package wire encodes and decodes the messages of the peer wire protocol
+// (BEP 3) and the extension protocol (BEP 10) that follow the handshake.
+// Every message is a 4 byte big-endian length, then a 1 byte ID and its
+// payload; a length of 0 is a keep-alive.
+package wire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type ID uint8
+
+const (
+	IDChoke         ID = 0
+	IDUnchoke       ID = 1
+	IDInterested    ID = 2
+	IDNotInterested ID = 3
+	IDHave          ID = 4
+	IDBitfield      ID = 5
+	IDRequest       ID = 6
+	IDPiece         ID = 7
+	IDCancel        ID = 8
+	IDPort          ID = 9
+	IDExtended      ID = 20
+)
+
+func (id ID) String() string {
+	switch id {
+	case IDChoke:
+		return "choke"
+	case IDUnchoke:
+		return "unchoke"
+	case IDInterested:
+		return "interested"
+	case IDNotInterested:
+		return "not interested"
+	case IDHave:
+		return "have"
+	case IDBitfield:
+		return "bitfield"
+	case IDRequest:
+		return "request"
+	case IDPiece:
+		return "piece"
+	case IDCancel:
+		return "cancel"
+	case IDPort:
+		return "port"
+	case IDExtended:
+		return "extended"
+	}
+	return fmt.Sprintf("message(%d)", uint8(id))
+}
+
+// DefaultMaxLength bounds the messages a Reader accepts: enough for a 16 KiB
+// block with room to spare, and the bitfield of a torrent with a million
+// pieces.
+const DefaultMaxLength = 1 << 18
+
+// Message is one decoded message. A keep-alive is KeepAlive.
+type Message interface {
+	ID() ID
+	// appendPayload appends everything after the ID.
+	appendPayload(b []byte) []byte
+}
+
+type KeepAlive struct{}
+type Choke struct{}
+type Unchoke struct{}
+type Interested struct{}
+type NotInterested struct{}
+
+type Have struct {
+	Index uint32
+}
+
+type Bitfield struct {
+	Bits []byte
+}
+
+type Request struct {
+	Index, Begin, Length uint32
+}
+
+type Piece struct {
+	Index, Begin uint32
+	Block        []byte
+}
+
+type Cancel struct {
+	Index, Begin, Length uint32
+}
+
+// Port is the DHT port of the peer (BEP 5).
+type Port struct {
+	Port uint16
+}
+
+// Extended is a BEP 10 message: ExtendedID 0 is the extension handshake,
+// anything else is the id the receiver assigned to an extension.
+type Extended struct {
+	ExtendedID uint8
+	Payload    []byte
+}
+
+// Unknown is a message with an ID this package doesn't know, kept so the
+// caller can skip or log it.
+type Unknown struct {
+	MessageID ID
+	Payload   []byte
+}
+
+func (KeepAlive) ID() ID     { return 0 }
+func (Choke) ID() ID         { return IDChoke }
+func (Unchoke) ID() ID       { return IDUnchoke }
+func (Interested) ID() ID    { return IDInterested }
+func (NotInterested) ID() ID { return IDNotInterested }
+func (Have) ID() ID          { return IDHave }
+func (Bitfield) ID() ID      { return IDBitfield }
+func (Request) ID() ID       { return IDRequest }
+func (Piece) ID() ID         { return IDPiece }
+func (Cancel) ID() ID        { return IDCancel }
+func (Port) ID() ID          { return IDPort }
+func (Extended) ID() ID      { return IDExtended }
+func (m Unknown) ID() ID     { return m.MessageID }
+
+func (KeepAlive) appendPayload(b []byte) []byte     { return b }
+func (Choke) appendPayload(b []byte) []byte         { return b }
+func (Unchoke) appendPayload(b []byte) []byte       { return b }
+func (Interested) appendPayload(b []byte) []byte    { return b }
+func (NotInterested) appendPayload(b []byte) []byte { return b }
+
+func (m Have) appendPayload(b []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, m.Index)
+}
+
+func (m Bitfield) appendPayload(b []byte) []byte {
+	return append(b, m.Bits...)
+}
+
+func (m Request) appendPayload(b []byte) []byte {
+	return appendBlockRef(b, m.Index, m.Begin, m.Length)
+}
+
+func (m Piece) appendPayload(b []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, m.Index)
+	b = binary.BigEndian.AppendUint32(b, m.Begin)
+	return append(b, m.Block...)
+}
+
+func (m Cancel) appendPayload(b []byte) []byte {
+	return appendBlockRef(b, m.Index, m.Begin, m.Length)
+}
+
+func (m Port) appendPayload(b []byte) []byte {
+	return binary.BigEndian.AppendUint16(b, m.Port)
+}
+
+func (m Extended) appendPayload(b []byte) []byte {
+	return append(append(b, m.ExtendedID), m.Payload...)
+}
+
+func (m Unknown) appendPayload(b []byte) []byte {
+	return append(b, m.Payload...)
+}
+
+func appendBlockRef(b []byte, index, begin, length uint32) []byte {
+	b = binary.BigEndian.AppendUint32(b, index)
+	b = binary.BigEndian.AppendUint32(b, begin)
+	return binary.BigEndian.AppendUint32(b, length)
+}
+
+// Marshal encodes m with its length prefix.
+func Marshal(m Message) []byte {
+	if _, ok := m.(KeepAlive); ok {
+		return make([]byte, 4)
+	}
+	b := m.appendPayload([]byte{0, 0, 0, 0, byte(m.ID())})
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(b)-4))
+	return b
+}
+
+// Reader decodes messages from a stream. It reads exactly one message at a
+// time and buffers nothing, so the stream can be handed on between readers.
+type Reader struct {
+	r io.Reader
+	// MaxLength is the longest message accepted, ID included.
+	MaxLength uint32
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r, MaxLength: DefaultMaxLength}
+}
+
+// ReadMessage reads the next message. A message longer than MaxLength is an
+// error and its payload is not read.
+func (r *Reader) ReadMessage() (Message, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r.r, prefix[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	if length == 0 {
+		return KeepAlive{}, nil
+	}
+	if length > r.MaxLength {
+		return nil, fmt.Errorf("wire: message of %d bytes exceeds limit of %d", length, r.MaxLength)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		// the stream ending after a length prefix is not a clean end
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return parse(ID(body[0]), body[1:])
+}
+
+func parse(id ID, payload []byte) (Message, error) {
+	// fixed-size messages must have exactly their size
+	size := -1
+	switch id {
+	case IDChoke, IDUnchoke, IDInterested, IDNotInterested:
+		size = 0
+	case IDHave:
+		size = 4
+	case IDRequest, IDCancel:
+		size = 12
+	case IDPort:
+		size = 2
+	case IDPiece:
+		if len(payload) < 8 {
+			return nil, fmt.Errorf("wire: %s message too short", id)
+		}
+	case IDExtended:
+		if len(payload) < 1 {
+			return nil, fmt.Errorf("wire: %s message too short", id)
+		}
+	}
+	if size >= 0 && len(payload) != size {
+		return nil, fmt.Errorf("wire: %s message has %d payload bytes, want %d", id, len(payload), size)
+	}
+
+	switch id {
+	case IDChoke:
+		return Choke{}, nil
+	case IDUnchoke:
+		return Unchoke{}, nil
+	case IDInterested:
+		return Interested{}, nil
+	case IDNotInterested:
+		return NotInterested{}, nil
+	case IDHave:
+		return Have{Index: binary.BigEndian.Uint32(payload)}, nil
+	case IDBitfield:
+		return Bitfield{Bits: payload}, nil
+	case IDRequest:
+		return Request{
+			Index:  binary.BigEndian.Uint32(payload[0:4]),
+			Begin:  binary.BigEndian.Uint32(payload[4:8]),
+			Length: binary.BigEndian.Uint32(payload[8:12]),
+		}, nil
+	case IDPiece:
+		return Piece{
+			Index: binary.BigEndian.Uint32(payload[0:4]),
+			Begin: binary.BigEndian.Uint32(payload[4:8]),
+			Block: payload[8:],
+		}, nil
+	case IDCancel:
+		return Cancel{
+			Index:  binary.BigEndian.Uint32(payload[0:4]),
+			Begin:  binary.BigEndian.Uint32(payload[4:8]),
+			Length: binary.BigEndian.Uint32(payload[8:12]),
+		}, nil
+	case IDPort:
+		return Port{Port: binary.BigEndian.Uint16(payload)}, nil
+	case IDExtended:
+		return Extended{ExtendedID: payload[0], Payload: payload[1:]}, nil
+	}
+	return Unknown{MessageID: id, Payload: payload}, nil
+}
+
+// Writer encodes messages onto a stream.
+type Writer struct {
+	w io.Writer
+	// MaxLength is the longest message sent, ID included.
+	MaxLength uint32
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, MaxLength: DefaultMaxLength}
+}
+
+// WriteMessage sends m in a single write, so messages written from
+// different goroutines never interleave on a connection.
+func (w *Writer) WriteMessage(m Message) error {
+	b := Marshal(m)
+	if uint32(len(b)-4) > w.MaxLength {
+		return fmt.Errorf("wire: %s message of %d bytes exceeds limit of %d", m.ID(), len(b)-4, w.MaxLength)
+	}
+	_, err := w.w.Write(b)
+	return err
+}
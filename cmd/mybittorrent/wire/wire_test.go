@@ -0,0 +1,122 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	messages := []Message{
+		KeepAlive{},
+		Choke{},
+		Unchoke{},
+		Interested{},
+		NotInterested{},
+		Have{Index: 70000},
+		Bitfield{Bits: []byte{0xff, 0x80}},
+		Request{Index: 1, Begin: 16384, Length: 16384},
+		Piece{Index: 2, Begin: 32768, Block: []byte("block data")},
+		Cancel{Index: 3, Begin: 0, Length: 100},
+		Port{Port: 6881},
+		Extended{ExtendedID: 0, Payload: []byte("d1:md6:ut_pexi1eee")},
+		Extended{ExtendedID: 3, Payload: []byte{}},
+		Unknown{MessageID: 13, Payload: []byte{1, 2, 3}},
+	}
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	for _, m := range messages {
+		if err := writer.WriteMessage(m); err != nil {
+			t.Fatalf("writing %s: %v", m.ID(), err)
+		}
+	}
+	reader := NewReader(&buf)
+	for _, want := range messages {
+		got, err := reader.ReadMessage()
+		if err != nil {
+			t.Fatalf("reading %s: %v", want.ID(), err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("read %#v, want %#v", got, want)
+		}
+	}
+	if _, err := reader.ReadMessage(); err != io.EOF {
+		t.Fatalf("read past the last message = %v, want io.EOF", err)
+	}
+}
+
+func TestKeepAliveEncoding(t *testing.T) {
+	if got := Marshal(KeepAlive{}); !bytes.Equal(got, []byte{0, 0, 0, 0}) {
+		t.Fatalf("keep-alive encoded as %v, want a zero length alone", got)
+	}
+	if got := Marshal(Have{Index: 1}); !bytes.Equal(got, []byte{0, 0, 0, 5, 4, 0, 0, 0, 1}) {
+		t.Fatalf("have encoded as %v", got)
+	}
+}
+
+func TestOversizedMessage(t *testing.T) {
+	stream := append([]byte{0xff, 0xff, 0xff, 0xff}, "rest of stream"...)
+	r := bytes.NewReader(stream)
+	reader := NewReader(r)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := reader.ReadMessage()
+	runtime.ReadMemStats(&after)
+	if err == nil {
+		t.Fatal("4 GiB message accepted")
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<16 {
+		t.Fatalf("rejecting the message allocated %d bytes", allocated)
+	}
+	if r.Len() != len("rest of stream") {
+		t.Fatal("payload of a rejected message was read")
+	}
+
+	// MaxLength itself is still accepted
+	reader = NewReader(bytes.NewReader(Marshal(Bitfield{Bits: make([]byte, 9)})))
+	reader.MaxLength = 10
+	if _, err := reader.ReadMessage(); err != nil {
+		t.Fatalf("message of exactly MaxLength: %v", err)
+	}
+	if err := (&Writer{w: io.Discard, MaxLength: 9}).WriteMessage(Bitfield{Bits: make([]byte, 9)}); err == nil {
+		t.Fatal("writer sent a message over its MaxLength")
+	}
+}
+
+func TestWrongSizeFixedMessages(t *testing.T) {
+	tests := []struct {
+		id      ID
+		payload []byte
+	}{
+		{IDChoke, []byte{0}},
+		{IDHave, []byte{0, 0, 1}},
+		{IDHave, []byte{0, 0, 0, 0, 1}},
+		{IDRequest, make([]byte, 11)},
+		{IDRequest, make([]byte, 13)},
+		{IDCancel, make([]byte, 8)},
+		{IDPort, []byte{1}},
+		{IDPort, []byte{0, 0, 1}},
+		{IDPiece, make([]byte, 7)},
+		{IDExtended, nil},
+	}
+	for _, tt := range tests {
+		stream := Marshal(Unknown{MessageID: tt.id, Payload: tt.payload})
+		if m, err := NewReader(bytes.NewReader(stream)).ReadMessage(); err == nil {
+			t.Errorf("%s with %d payload bytes read as %#v", tt.id, len(tt.payload), m)
+		}
+	}
+}
+
+func TestTruncatedStream(t *testing.T) {
+	stream := Marshal(Piece{Index: 1, Begin: 0, Block: []byte("0123456789")})
+	for _, n := range []int{2, 4, 5, 12, len(stream) - 1} {
+		_, err := NewReader(bytes.NewReader(stream[:n])).ReadMessage()
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("stream cut after %d bytes: %v, want io.ErrUnexpectedEOF", n, err)
+		}
+	}
+}
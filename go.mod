@@ -9,5 +9,3 @@
 module github.com/codecrafters-io/bittorrent-starter-go
 
 go 1.22
-
-require github.com/jackpal/bencode-go v1.0.2 // indirect